@@ -0,0 +1,54 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// apiKeys holds the keys accepted in the X-API-Key header, from API_KEYS
+var apiKeys []string
+
+func loadAPIKeys() []string {
+	keys := make([]string, 0)
+	for _, key := range strings.Split(os.Getenv("API_KEYS"), ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// apiKeyOwner returns the owner ID for a valid API key on the request.
+// Keys are never stored; owners are identified by a hash prefix.
+func apiKeyOwner(r *http.Request) (string, bool) {
+	key := r.Header.Get("X-API-Key")
+	if key == "" {
+		key = r.URL.Query().Get("api_key")
+	}
+	if key == "" {
+		return "", false
+	}
+
+	for _, valid := range apiKeys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(valid)) == 1 {
+			sum := sha256.Sum256([]byte(key))
+			return "key:" + hex.EncodeToString(sum[:6]), true
+		}
+	}
+	return "", false
+}
+
+// telegramOwner returns the owner ID for a Telegram chat
+func telegramOwner(chatID int64) string {
+	return "chat:" + strconv.FormatInt(chatID, 10)
+}
+
+// isTelegramOwner reports whether a job was submitted through the bot
+func isTelegramOwner(owner string) bool {
+	return strings.HasPrefix(owner, "chat:")
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAPIKeyOwner(t *testing.T) {
+	defer func(keys []string) { apiKeys = keys }(apiKeys)
+	t.Setenv("API_KEYS", " alpha, ,beta ")
+	apiKeys = loadAPIKeys()
+	if !reflect.DeepEqual(apiKeys, []string{"alpha", "beta"}) {
+		t.Fatalf("loadAPIKeys() = %q", apiKeys)
+	}
+
+	owner := func(header, query string) (string, bool) {
+		req := httptest.NewRequest(http.MethodGet, "/api/defaults?api_key="+query, nil)
+		if header != "" {
+			req.Header.Set("X-API-Key", header)
+		}
+		return apiKeyOwner(req)
+	}
+
+	alpha, ok := owner("alpha", "")
+	if !ok || !strings.HasPrefix(alpha, "key:") || strings.Contains(alpha, "alpha") {
+		t.Errorf("owner of alpha = %q, %v", alpha, ok)
+	}
+	if fromQuery, _ := owner("", "alpha"); fromQuery != alpha {
+		t.Errorf("query key owner = %q, want %q", fromQuery, alpha)
+	}
+	if beta, _ := owner("beta", ""); beta == alpha {
+		t.Error("two keys share an owner")
+	}
+	if _, ok := owner("gamma", ""); ok {
+		t.Error("unknown key accepted")
+	}
+	if _, ok := owner("", ""); ok {
+		t.Error("missing key accepted")
+	}
+}
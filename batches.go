@@ -0,0 +1,480 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// Batch limits
+const (
+	MaxBatchJobs       = 200
+	MaxBatchURLs       = 50
+	URLDownloadTimeout = 10 * time.Minute
+)
+
+// Batch groups jobs submitted together with shared options
+type Batch struct {
+	ID        string            `json:"id"`
+	CreatedAt time.Time         `json:"created_at"`
+	Options   map[string]string `json:"options"`
+	Owner     string            `json:"-"`
+	Cancelled bool              `json:"cancelled,omitempty"`
+	// Webhook for jobs that do not set their own
+	WebhookURL    string `json:"-"`
+	WebhookSecret string `json:"-"`
+
+	jobs             []*Job
+	cleanupScheduled bool
+}
+
+// BatchStatus is the aggregate view of a batch sent over REST and WebSocket
+type BatchStatus struct {
+	ID        string            `json:"id"`
+	CreatedAt time.Time         `json:"created_at"`
+	Options   map[string]string `json:"options"`
+	State     string            `json:"state"` // empty, queued, processing, cancelling, completed, partial, failed, cancelled
+	Progress  int               `json:"progress"`
+	Total     int               `json:"total"`
+	Counts    map[string]int    `json:"counts"`
+	JobIDs    []string          `json:"job_ids"`
+	Download  string            `json:"download,omitempty"`
+}
+
+// statusLocked aggregates the batch's jobs. Caller must hold queue.mu.
+func (b *Batch) statusLocked() BatchStatus {
+	status := BatchStatus{
+		ID:        b.ID,
+		CreatedAt: b.CreatedAt,
+		Options:   b.Options,
+		Total:     len(b.jobs),
+		Counts:    make(map[string]int),
+		JobIDs:    make([]string, 0, len(b.jobs)),
+	}
+
+	progress := 0
+	for _, job := range b.jobs {
+		status.JobIDs = append(status.JobIDs, job.ID)
+		status.Counts[job.Status]++
+		if isFinalStatus(job.Status) {
+			progress += 100
+		} else {
+			progress += job.Progress
+		}
+	}
+
+	if status.Total == 0 {
+		status.State = "empty"
+		return status
+	}
+	status.Progress = progress / status.Total
+
+	finished := status.Counts["completed"] + status.Counts["failed"] + status.Counts["cancelled"]
+	switch {
+	case finished < status.Total && b.Cancelled:
+		status.State = "cancelling"
+	case finished < status.Total && status.Counts["processing"] == 0 && status.Counts["completed"] == 0:
+		status.State = "queued"
+	case finished < status.Total:
+		status.State = "processing"
+	case status.Counts["completed"] == status.Total:
+		status.State = "completed"
+	case b.Cancelled && status.Counts["completed"] == 0:
+		status.State = "cancelled"
+	case status.Counts["completed"] == 0:
+		status.State = "failed"
+	default:
+		status.State = "partial"
+	}
+
+	if finished == status.Total && status.Counts["completed"] > 0 {
+		status.Download = "/api/batches/" + b.ID + "/download"
+	}
+	return status
+}
+
+func isFinalStatus(status string) bool {
+	return status == "completed" || status == "failed" || status == "cancelled"
+}
+
+// broadcastBatchUpdate sends the batch aggregate after one of its jobs changed
+// and schedules the batch for removal once every job has finished
+func broadcastBatchUpdate(batchID string) {
+	queue.mu.Lock()
+	batch, exists := queue.batches[batchID]
+	if !exists {
+		queue.mu.Unlock()
+		return
+	}
+	status := batch.statusLocked()
+	payload, err := json.Marshal(status)
+	done := status.Download != "" || status.State == "failed" || status.State == "cancelled"
+	if done && !batch.cleanupScheduled {
+		batch.cleanupScheduled = true
+		time.AfterFunc(1*time.Hour, func() {
+			queue.mu.Lock()
+			delete(queue.batches, batchID)
+			queue.mu.Unlock()
+		})
+	}
+	queue.mu.Unlock()
+
+	if err != nil {
+		log.Printf("Batch %s encoding failed: %v", batchID, err)
+		return
+	}
+	hub.Publish(&Event{Type: "batch_update", BatchID: batchID, Owner: batch.Owner, Payload: payload})
+}
+
+// lookupBatch finds a batch from the {id} route variable, writing a 404 if missing
+func lookupBatch(w http.ResponseWriter, r *http.Request) (*Batch, bool) {
+	queue.mu.RLock()
+	batch, exists := queue.batches[mux.Vars(r)["id"]]
+	queue.mu.RUnlock()
+
+	if !exists {
+		http.Error(w, "Batch not found", http.StatusNotFound)
+		return nil, false
+	}
+	return batch, true
+}
+
+// addJobToBatch registers job with the batch, failing if it is closed or full
+func addJobToBatch(batch *Batch, job *Job) error {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	if batch.Cancelled {
+		return fmt.Errorf("batch was cancelled")
+	}
+	if len(batch.jobs) >= MaxBatchJobs {
+		return fmt.Errorf("batch already has %d jobs", MaxBatchJobs)
+	}
+	job.BatchID = batch.ID
+	if job.WebhookURL == "" {
+		job.WebhookURL = batch.WebhookURL
+		job.WebhookSecret = batch.WebhookSecret
+	}
+	batch.jobs = append(batch.jobs, job)
+	return nil
+}
+
+// batchDefaults layers the batch options over the owner's defaults
+func batchDefaults(batch *Batch) map[string]string {
+	defaults := make(map[string]string)
+	if batch.Owner != "" {
+		defaults = defaultsStore.Get(batch.Owner)
+	}
+	for k, v := range batch.Options {
+		defaults[k] = v
+	}
+	return defaults
+}
+
+// downloadURLJob fetches a remote video for a batch job and queues it.
+// The job stays in the "downloading" state until the file is on disk.
+func downloadURLJob(job *Job, rawURL string) {
+	ctx, cancel := context.WithTimeout(context.Background(), URLDownloadTimeout)
+	defer cancel()
+
+	queue.mu.Lock()
+	job.cancel = cancel
+	queue.mu.Unlock()
+
+	uploadPath := sourcePath(job)
+	size, err := downloadURLToFile(ctx, rawURL, uploadPath, MaxFileSize)
+	if err == nil {
+		// Make sure we actually fetched something ffmpeg can convert
+		var info *MediaInfo
+		if info, err = probeMedia(ctx, uploadPath); err == nil && info.VideoStream() == nil {
+			err = fmt.Errorf("no video stream found")
+		}
+	}
+
+	queue.mu.Lock()
+	job.cancel = nil
+	switch {
+	case job.cancelRequested:
+		finishCancelledJobLocked(job)
+	case err != nil:
+		job.Status = "failed"
+		job.Error = "Download failed: " + err.Error()
+		job.CompletedAt = time.Now()
+		keepFailedJobLocked(job)
+	default:
+		job.FileSize = size
+		job.Status = "queued"
+		queue.jobs = append(queue.jobs, job)
+		for i, j := range queue.jobs {
+			j.QueuePos = i + 1
+		}
+	}
+	queued := job.Status == "queued"
+	queue.mu.Unlock()
+
+	if !queued {
+		os.Remove(uploadPath)
+		log.Printf("Job %s: URL download ended as %s: %v", job.ID, job.Status, err)
+	}
+	broadcastUpdate(job)
+}
+
+// downloadURLToFile streams url to path, refusing bodies larger than limit
+func downloadURLToFile(ctx context.Context, rawURL, path string, limit int64) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := urlInputClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("server returned %s", resp.Status)
+	}
+	if resp.ContentLength > limit {
+		return 0, fmt.Errorf("file is larger than %d MB", limit/(1024*1024))
+	}
+
+	out, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	defer out.Close()
+
+	n, err := io.Copy(out, io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return n, err
+	}
+	if n > limit {
+		return n, fmt.Errorf("file is larger than %d MB", limit/(1024*1024))
+	}
+	return n, nil
+}
+
+// fileNameFromURL derives an input name from the URL path
+func fileNameFromURL(u *url.URL) string {
+	name := sanitizeFilename(path.Base(u.Path))
+	if name == "" || name == "." || name == "/" {
+		name = "video"
+	}
+	if path.Ext(name) == "" {
+		name += ".webm"
+	}
+	return name
+}
+
+// Batch handlers
+
+func handleCreateBatch(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Options       map[string]string `json:"options"`
+		WebhookURL    string            `json:"webhook_url"`
+		WebhookSecret string            `json:"webhook_secret"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(io.LimitReader(r.Body, 64*1024)).Decode(&request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	webhookURL, err := parseWebhookURL(request.WebhookURL)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	owner, _ := apiKeyOwner(r)
+	batch := &Batch{
+		ID:            uuid.New().String(),
+		CreatedAt:     time.Now(),
+		Options:       make(map[string]string),
+		Owner:         owner,
+		WebhookURL:    webhookURL,
+		WebhookSecret: request.WebhookSecret,
+		jobs:          make([]*Job, 0),
+	}
+
+	for key, value := range request.Options {
+		if !isConversionOptionKey(key) {
+			http.Error(w, fmt.Sprintf("unknown option %q", key), http.StatusBadRequest)
+			return
+		}
+		batch.Options[key] = value
+	}
+	defaults := batchDefaults(batch)
+	if _, err := parseConversionOptions(func(key string) string { return defaults[key] }); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	queue.mu.Lock()
+	queue.batches[batch.ID] = batch
+	status := batch.statusLocked()
+	queue.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(status)
+}
+
+func handleGetBatch(w http.ResponseWriter, r *http.Request) {
+	batch, ok := lookupBatch(w, r)
+	if !ok {
+		return
+	}
+
+	queue.mu.RLock()
+	status := batch.statusLocked()
+	queue.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// handleBatchUpload accepts one file into the batch, with the same form
+// fields as /api/upload layered over the batch options
+func handleBatchUpload(w http.ResponseWriter, r *http.Request) {
+	batch, ok := lookupBatch(w, r)
+	if !ok {
+		return
+	}
+	acceptUpload(w, r, batch)
+}
+
+// handleBatchURLs queues remote videos, e.g. {"urls": ["https://…/a.webm"]}
+func handleBatchURLs(w http.ResponseWriter, r *http.Request) {
+	batch, ok := lookupBatch(w, r)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		URLs []string `json:"urls"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 256*1024)).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(request.URLs) == 0 || len(request.URLs) > MaxBatchURLs {
+		http.Error(w, fmt.Sprintf("Between 1 and %d URLs are required", MaxBatchURLs), http.StatusBadRequest)
+		return
+	}
+
+	parsed := make([]*url.URL, 0, len(request.URLs))
+	for _, raw := range request.URLs {
+		u, err := url.Parse(strings.TrimSpace(raw))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			http.Error(w, fmt.Sprintf("Invalid URL %q (http or https only)", raw), http.StatusBadRequest)
+			return
+		}
+		parsed = append(parsed, u)
+	}
+
+	defaults := batchDefaults(batch)
+	options, err := parseConversionOptions(func(key string) string { return defaults[key] })
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	watermark := &Job{Options: options}
+	if err := resolveWatermarkImage(watermark, batch.Owner); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	jobs := make([]*Job, 0, len(parsed))
+	for _, u := range parsed {
+		fileName := fileNameFromURL(u)
+		job := &Job{
+			ID:            uuid.New().String(),
+			FileName:      fileName,
+			OutputName:    withOutputExtension(getDefaultName(fileName), options),
+			Status:        "downloading",
+			CreatedAt:     time.Now(),
+			Options:       options,
+			Owner:         batch.Owner,
+			WatermarkPath: watermark.WatermarkPath,
+		}
+
+		if err := addJobToBatch(batch, job); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		jobs = append(jobs, job)
+		go downloadURLJob(job, u.String())
+	}
+
+	for _, job := range jobs {
+		broadcastUpdate(job)
+	}
+
+	queue.mu.RLock()
+	defer queue.mu.RUnlock()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(jobs)
+}
+
+func handleCancelBatch(w http.ResponseWriter, r *http.Request) {
+	batch, ok := lookupBatch(w, r)
+	if !ok {
+		return
+	}
+
+	queue.mu.Lock()
+	batch.Cancelled = true
+	jobs := append([]*Job(nil), batch.jobs...)
+	queue.mu.Unlock()
+
+	for _, job := range jobs {
+		cancelJob(job)
+	}
+	broadcastBatchUpdate(batch.ID)
+
+	queue.mu.RLock()
+	status := batch.statusLocked()
+	queue.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// handleBatchDownload streams the completed outputs of a finished batch
+func handleBatchDownload(w http.ResponseWriter, r *http.Request) {
+	batch, ok := lookupBatch(w, r)
+	if !ok {
+		return
+	}
+
+	queue.mu.RLock()
+	status := batch.statusLocked()
+	jobIDs := make([]string, 0, len(batch.jobs))
+	for _, job := range batch.jobs {
+		if job.Status == "completed" {
+			jobIDs = append(jobIDs, job.ID)
+		}
+	}
+	queue.mu.RUnlock()
+
+	if status.Download == "" {
+		http.Error(w, "Batch is not finished or has no completed jobs", http.StatusConflict)
+		return
+	}
+
+	serveJobsArchive(w, jobIDs)
+}
@@ -0,0 +1,407 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Trim modes
+const (
+	TrimModeFast     = "fast"     // seek to the nearest keyframe
+	TrimModeAccurate = "accurate" // decode and discard up to the exact frame
+)
+
+// Quality presets, mapped to a libx264 CRF by qualityArgs
+const (
+	QualityLow    = "low"
+	QualityMedium = "medium"
+	QualityHigh   = "high"
+)
+
+var qualityCRF = map[string]string{
+	QualityLow:    "32",
+	QualityMedium: "28",
+	QualityHigh:   "23",
+}
+
+// ConversionOptions holds the per-job settings chosen at submit time
+type ConversionOptions struct {
+	TrimStart    float64 `json:"trim_start,omitempty"`
+	TrimEnd      float64 `json:"trim_end,omitempty"`
+	TrimDuration float64 `json:"trim_duration,omitempty"`
+	TrimMode     string  `json:"trim_mode,omitempty"`
+
+	// Transforms, composed by videoFilterChains
+	MaxWidth  int          `json:"max_width,omitempty"`
+	MaxHeight int          `json:"max_height,omitempty"`
+	Crop      *CropRect    `json:"crop,omitempty"`
+	AutoCrop  bool         `json:"auto_crop,omitempty"`
+	Rotate    int          `json:"rotate,omitempty"`
+	Flip      string       `json:"flip,omitempty"`
+	PadAspect *AspectRatio `json:"pad_aspect,omitempty"`
+
+	// Fit the output into this many MB using a two-pass encode
+	TargetSizeMB float64 `json:"target_size_mb,omitempty"`
+
+	// Quality preset for single-pass encodes, and dropping the audio
+	Quality string `json:"quality,omitempty"`
+	Mute    bool   `json:"mute,omitempty"`
+
+	// Also render a short animated WebP preview
+	AnimatedPreview bool `json:"animated_preview,omitempty"`
+
+	// Container, metadata, chapters and subtitles
+	Format        string `json:"format,omitempty"`
+	StripMetadata bool   `json:"strip_metadata,omitempty"`
+	DropChapters  bool   `json:"drop_chapters,omitempty"`
+	Title         string `json:"title,omitempty"`
+	Author        string `json:"author,omitempty"`
+	Comment       string `json:"comment,omitempty"`
+	Subtitles     string `json:"subtitles,omitempty"`
+
+	// Burn-in style overrides
+	SubtitleFont     string `json:"subtitle_font,omitempty"`
+	SubtitleSize     int    `json:"subtitle_size,omitempty"`
+	SubtitlePosition string `json:"subtitle_position,omitempty"`
+
+	// Logo and/or text overlay
+	WatermarkText     string  `json:"watermark_text,omitempty"`
+	WatermarkImage    bool    `json:"watermark_image,omitempty"`
+	WatermarkPosition string  `json:"watermark_position,omitempty"`
+	WatermarkOpacity  float64 `json:"watermark_opacity,omitempty"`
+	WatermarkScale    float64 `json:"watermark_scale,omitempty"`
+	WatermarkStart    float64 `json:"watermark_start,omitempty"`
+	WatermarkEnd      float64 `json:"watermark_end,omitempty"`
+
+	// Adaptive streaming packaging instead of a single file
+	OutputMode string      `json:"output_mode,omitempty"`
+	Renditions []Rendition `json:"renditions,omitempty"`
+}
+
+// conversionOptionKeys lists every key parseConversionOptions reads; only
+// these may be stored as per-owner defaults
+var conversionOptionKeys = []string{
+	"start", "end", "duration", "trim_mode",
+	"max_width", "max_height", "crop", "autocrop", "rotate", "flip", "pad_aspect",
+	"target_size_mb", "quality", "mute", "preview",
+	"format", "metadata", "chapters", "title", "author", "comment", "subtitles",
+	"subtitle_font", "subtitle_size", "subtitle_position",
+	"watermark_text", "watermark_image", "watermark_position", "watermark_opacity",
+	"watermark_scale", "watermark_start", "watermark_end",
+	"output_mode", "renditions",
+}
+
+func isConversionOptionKey(key string) bool {
+	for _, k := range conversionOptionKeys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// parseConversionOptions reads options through get, which returns the raw
+// value for a key (form field or caption token) or "" when absent
+func parseConversionOptions(get func(string) string) (ConversionOptions, error) {
+	var opts ConversionOptions
+	var err error
+
+	if v := get("start"); v != "" {
+		if opts.TrimStart, err = parseTimestamp(v); err != nil {
+			return opts, fmt.Errorf("invalid start: %v", err)
+		}
+	}
+	if v := get("end"); v != "" {
+		if opts.TrimEnd, err = parseTimestamp(v); err != nil {
+			return opts, fmt.Errorf("invalid end: %v", err)
+		}
+	}
+	if v := get("duration"); v != "" {
+		if opts.TrimDuration, err = parseTimestamp(v); err != nil {
+			return opts, fmt.Errorf("invalid duration: %v", err)
+		}
+	}
+
+	if opts.TrimEnd > 0 && opts.TrimDuration > 0 {
+		return opts, fmt.Errorf("use either end or duration, not both")
+	}
+	if opts.TrimEnd > 0 && opts.TrimEnd <= opts.TrimStart {
+		return opts, fmt.Errorf("end must be after start")
+	}
+
+	switch mode := strings.ToLower(get("trim_mode")); mode {
+	case "", TrimModeFast:
+		if opts.IsTrimmed() {
+			opts.TrimMode = TrimModeFast
+		}
+	case TrimModeAccurate:
+		opts.TrimMode = TrimModeAccurate
+	default:
+		return opts, fmt.Errorf("invalid trim_mode %q (use fast or accurate)", mode)
+	}
+
+	if err := opts.parseTransforms(get); err != nil {
+		return opts, err
+	}
+
+	if v := get("target_size_mb"); v != "" {
+		if opts.TargetSizeMB, err = parseTargetSize(v); err != nil {
+			return opts, err
+		}
+	}
+
+	switch v := strings.ToLower(get("quality")); v {
+	case "":
+	case QualityLow, QualityMedium, QualityHigh:
+		opts.Quality = v
+	default:
+		return opts, fmt.Errorf("quality must be low, medium or high")
+	}
+	if v := get("mute"); v != "" {
+		if opts.Mute, err = strconv.ParseBool(v); err != nil {
+			return opts, fmt.Errorf("mute must be true or false")
+		}
+	}
+
+	if err := opts.parseMetadataOptions(get); err != nil {
+		return opts, err
+	}
+
+	if err := opts.parseStreamingOptions(get); err != nil {
+		return opts, err
+	}
+
+	if err := opts.parseSubtitleStyle(get); err != nil {
+		return opts, err
+	}
+
+	if err := opts.parseWatermarkOptions(get); err != nil {
+		return opts, err
+	}
+
+	if v := get("preview"); v != "" {
+		if opts.AnimatedPreview, err = strconv.ParseBool(v); err != nil {
+			return opts, fmt.Errorf("preview must be true or false")
+		}
+	}
+
+	return opts, nil
+}
+
+func (o *ConversionOptions) parseTransforms(get func(string) string) error {
+	var err error
+
+	if v := get("max_width"); v != "" {
+		if o.MaxWidth, err = parseDimension("max_width", v); err != nil {
+			return err
+		}
+	}
+	if v := get("max_height"); v != "" {
+		if o.MaxHeight, err = parseDimension("max_height", v); err != nil {
+			return err
+		}
+	}
+	if v := get("crop"); v != "" {
+		if o.Crop, err = parseCropRect(v); err != nil {
+			return err
+		}
+	}
+	if v := get("autocrop"); v != "" {
+		if o.AutoCrop, err = strconv.ParseBool(v); err != nil {
+			return fmt.Errorf("autocrop must be true or false")
+		}
+	}
+	if o.AutoCrop && o.Crop != nil {
+		return fmt.Errorf("use either crop or autocrop, not both")
+	}
+	if v := get("rotate"); v != "" {
+		o.Rotate, err = strconv.Atoi(v)
+		if err != nil || (o.Rotate != 0 && o.Rotate != 90 && o.Rotate != 180 && o.Rotate != 270) {
+			return fmt.Errorf("rotate must be 0, 90, 180 or 270")
+		}
+	}
+	switch v := strings.ToLower(get("flip")); v {
+	case "", "none":
+	case "h", "horizontal":
+		o.Flip = "h"
+	case "v", "vertical":
+		o.Flip = "v"
+	case "hv", "vh", "both":
+		o.Flip = "hv"
+	default:
+		return fmt.Errorf("flip must be h, v or both")
+	}
+	if v := get("pad_aspect"); v != "" {
+		if o.PadAspect, err = parseAspectRatio(v); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// parseCaptionOptions parses Telegram caption syntax such as
+// "start=0:10 end=1:30 mode=accurate" or the shorthand "0:10-1:30".
+// Keys missing from the caption fall back to defaults.
+func parseCaptionOptions(caption string, defaults map[string]string) (ConversionOptions, error) {
+	values := captionValues(caption)
+	return parseConversionOptions(withDefaults(func(key string) string { return values[key] }, defaults))
+}
+
+// captionValues splits caption tokens into option keys and raw values
+func captionValues(caption string) map[string]string {
+	values := make(map[string]string)
+
+	for _, token := range strings.Fields(caption) {
+		if key, value, ok := strings.Cut(token, "="); ok {
+			key = strings.ToLower(key)
+			switch key {
+			case "mode", "trim":
+				key = "trim_mode"
+			case "dur", "length":
+				key = "duration"
+			case "size", "target", "fit":
+				key = "target_size_mb"
+			case "watermark", "wm":
+				key = "watermark_text"
+			case "logo":
+				key = "watermark_image"
+			}
+			values[key] = value
+			continue
+		}
+
+		if start, end, ok := strings.Cut(token, "-"); ok && looksLikeTimestamp(start) && looksLikeTimestamp(end) {
+			values["start"] = start
+			values["end"] = end
+		}
+	}
+
+	return values
+}
+
+// IsTrimmed reports whether only a segment of the input is converted
+func (o ConversionOptions) IsTrimmed() bool {
+	return o.TrimStart > 0 || o.TrimEnd > 0 || o.TrimDuration > 0
+}
+
+// ClipDuration returns the length of the converted segment given the full
+// input duration, or 0 if neither is known
+func (o ConversionOptions) ClipDuration(full float64) float64 {
+	end := full
+	if o.TrimEnd > 0 && (full <= 0 || o.TrimEnd < full) {
+		end = o.TrimEnd
+	}
+	if o.TrimDuration > 0 && (end <= 0 || o.TrimStart+o.TrimDuration < end) {
+		end = o.TrimStart + o.TrimDuration
+	}
+	if end <= o.TrimStart {
+		return 0
+	}
+	return end - o.TrimStart
+}
+
+// Validate checks the options against the probed input, which may be nil
+// if probing failed
+func (o ConversionOptions) Validate(info *MediaInfo) error {
+	if info == nil {
+		return nil
+	}
+
+	if info.Duration > 0 && o.TrimStart >= info.Duration {
+		return fmt.Errorf("start %s is beyond the end of the video (%s)",
+			formatTimestamp(o.TrimStart), formatTimestamp(info.Duration))
+	}
+
+	video := info.VideoStream()
+	if video == nil {
+		return fmt.Errorf("the file has no video stream")
+	}
+
+	// ffmpeg applies the rotation metadata before our filters run
+	width, height := video.Width, video.Height
+	if video.Rotation%180 != 0 {
+		width, height = height, width
+	}
+	if o.Crop != nil && width > 0 && height > 0 &&
+		(o.Crop.X+o.Crop.Width > width || o.Crop.Y+o.Crop.Height > height) {
+		return fmt.Errorf("crop %s does not fit inside the %dx%d video", o.Crop, width, height)
+	}
+	return nil
+}
+
+// inputArgs returns the ffmpeg arguments placed before -i
+func (o ConversionOptions) inputArgs() []string {
+	if !o.IsTrimmed() {
+		return nil
+	}
+
+	args := make([]string, 0)
+	if o.TrimMode == TrimModeAccurate {
+		args = append(args, "-accurate_seek")
+	} else {
+		args = append(args, "-noaccurate_seek")
+	}
+	if o.TrimStart > 0 {
+		args = append(args, "-ss", formatSeconds(o.TrimStart))
+	}
+	return args
+}
+
+// seekArgs returns just the start offset, for secondary inputs
+func (o ConversionOptions) seekArgs() []string {
+	if o.TrimStart > 0 {
+		return []string{"-ss", formatSeconds(o.TrimStart)}
+	}
+	return nil
+}
+
+// trimArgs returns the output duration limit placed after -i
+func (o ConversionOptions) trimArgs() []string {
+	if clip := o.ClipDuration(0); clip > 0 {
+		return []string{"-t", formatSeconds(clip)}
+	}
+	return nil
+}
+
+// timestampPattern matches plain decimal seconds or [hh:]mm:ss[.fff]; it
+// keeps NaN, Inf and exponents away from ParseFloat
+var timestampPattern = regexp.MustCompile(`^(?:(?:\d+:)?\d+:)?\d+(?:\.\d+)?$`)
+
+// parseTimestamp accepts seconds ("90", "90.5") or [hh:]mm:ss[.fff]
+func parseTimestamp(value string) (float64, error) {
+	value = strings.TrimSpace(value)
+	if !timestampPattern.MatchString(value) {
+		return 0, fmt.Errorf("%q is not a timestamp", value)
+	}
+	parts := strings.Split(value, ":")
+
+	var seconds float64
+	for i, part := range parts {
+		n, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%q is not a timestamp", value)
+		}
+		if i > 0 && n >= 60 {
+			return 0, fmt.Errorf("%q is not a timestamp", value)
+		}
+		seconds = seconds*60 + n
+	}
+	return seconds, nil
+}
+
+func looksLikeTimestamp(value string) bool {
+	_, err := parseTimestamp(value)
+	return err == nil && value != ""
+}
+
+func formatSeconds(seconds float64) string {
+	return strconv.FormatFloat(seconds, 'f', 3, 64)
+}
+
+func formatTimestamp(seconds float64) string {
+	total := int(seconds)
+	return fmt.Sprintf("%02d:%02d:%02d", total/3600, (total%3600)/60, total%60)
+}
@@ -0,0 +1,67 @@
+package main
+
+import "testing"
+
+func TestParseTimestamp(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    float64
+		wantErr bool
+	}{
+		{"90", 90, false},
+		{"90.5", 90.5, false},
+		{" 5 ", 5, false},
+		{"1:30", 90, false},
+		{"01:02:03.250", 3723.25, false},
+		{"0:59.9", 59.9, false},
+		{"", 0, true},
+		{"1:60", 0, true},
+		{"1:2:3:4", 0, true},
+		{"-5", 0, true},
+		{"NaN", 0, true},
+		{"Inf", 0, true},
+		{"+Inf", 0, true},
+		{"1e9", 0, true},
+		{"0x10", 0, true},
+		{"1.5:30", 0, true},
+		{"1:", 0, true},
+		{"abc", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseTimestamp(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseTimestamp(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && got != tt.want {
+			t.Errorf("parseTimestamp(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestClipDuration(t *testing.T) {
+	tests := []struct {
+		name string
+		opts ConversionOptions
+		full float64
+		want float64
+	}{
+		{"no trim, unknown length", ConversionOptions{}, 0, 0},
+		{"no trim", ConversionOptions{}, 60, 60},
+		{"start only", ConversionOptions{TrimStart: 10}, 60, 50},
+		{"end only", ConversionOptions{TrimEnd: 30}, 60, 30},
+		{"end past the video", ConversionOptions{TrimEnd: 90}, 60, 60},
+		{"start and end", ConversionOptions{TrimStart: 10, TrimEnd: 30}, 60, 20},
+		{"duration", ConversionOptions{TrimStart: 10, TrimDuration: 5}, 60, 5},
+		{"duration, unknown length", ConversionOptions{TrimStart: 10, TrimDuration: 5}, 0, 5},
+		{"end before duration", ConversionOptions{TrimStart: 10, TrimEnd: 12, TrimDuration: 5}, 60, 2},
+		{"start past end", ConversionOptions{TrimStart: 40, TrimEnd: 30}, 60, 0},
+	}
+
+	for _, tt := range tests {
+		if got := tt.opts.ClipDuration(tt.full); got != tt.want {
+			t.Errorf("%s: ClipDuration(%v) = %v, want %v", tt.name, tt.full, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// DefaultsStore keeps per-owner default option values (the same keys as
+// the upload form), persisted as JSON in DataDir
+type DefaultsStore struct {
+	mu     sync.RWMutex
+	path   string
+	values map[string]map[string]string
+}
+
+var defaultsStore *DefaultsStore
+
+func NewDefaultsStore(path string) *DefaultsStore {
+	store := &DefaultsStore{
+		path:   path,
+		values: make(map[string]map[string]string),
+	}
+
+	data, err := os.ReadFile(path)
+	if err == nil {
+		if err := json.Unmarshal(data, &store.values); err != nil {
+			log.Printf("Warning: ignoring corrupt defaults file %s: %v", path, err)
+		}
+	}
+	return store
+}
+
+// Get returns a copy of the owner's defaults
+func (s *DefaultsStore) Get(owner string) map[string]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	values := make(map[string]string)
+	for k, v := range s.values[owner] {
+		values[k] = v
+	}
+	return values
+}
+
+// Set validates and replaces the owner's defaults
+func (s *DefaultsStore) Set(owner string, values map[string]string) error {
+	if err := validateDefaults(values); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.setLocked(owner, values)
+}
+
+// Update merges values into the owner's defaults; empty values remove keys.
+// The read, merge and write happen under one lock so concurrent updates
+// don't lose each other's changes.
+func (s *DefaultsStore) Update(owner string, values map[string]string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	merged := make(map[string]string)
+	for k, v := range s.values[owner] {
+		merged[k] = v
+	}
+	for k, v := range values {
+		if v == "" {
+			delete(merged, k)
+		} else {
+			merged[k] = v
+		}
+	}
+
+	if err := validateDefaults(merged); err != nil {
+		return err
+	}
+	return s.setLocked(owner, merged)
+}
+
+// validateDefaults checks that values are known, valid option values
+func validateDefaults(values map[string]string) error {
+	for key := range values {
+		if !isConversionOptionKey(key) {
+			return fmt.Errorf("unknown option %q", key)
+		}
+	}
+	_, err := parseConversionOptions(func(key string) string { return values[key] })
+	return err
+}
+
+// setLocked replaces the owner's defaults and saves. Caller must hold s.mu.
+func (s *DefaultsStore) setLocked(owner string, values map[string]string) error {
+	if len(values) == 0 {
+		delete(s.values, owner)
+	} else {
+		s.values[owner] = values
+	}
+	return s.save()
+}
+
+// save writes the store atomically. Caller must hold s.mu.
+func (s *DefaultsStore) save() error {
+	data, err := json.MarshalIndent(s.values, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// WatermarkPath is where the owner's default logo is stored
+func (s *DefaultsStore) WatermarkPath(owner string) string {
+	return filepath.Join(DataDir, "watermarks", strings.ReplaceAll(owner, ":", "_")+".png")
+}
+
+// withDefaults layers owner defaults under request values
+func withDefaults(get func(string) string, defaults map[string]string) func(string) string {
+	return func(key string) string {
+		if v := get(key); v != "" {
+			return v
+		}
+		return defaults[key]
+	}
+}
+
+// Defaults handlers (API key required)
+
+func handleGetDefaults(w http.ResponseWriter, r *http.Request) {
+	owner, ok := apiKeyOwner(r)
+	if !ok {
+		http.Error(w, "Valid X-API-Key required", http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(defaultsStore.Get(owner))
+}
+
+func handlePutDefaults(w http.ResponseWriter, r *http.Request) {
+	owner, ok := apiKeyOwner(r)
+	if !ok {
+		http.Error(w, "Valid X-API-Key required", http.StatusUnauthorized)
+		return
+	}
+
+	var values map[string]string
+	if err := json.NewDecoder(io.LimitReader(r.Body, 64*1024)).Decode(&values); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := defaultsStore.Set(owner, values); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(defaultsStore.Get(owner))
+}
+
+// handlePutDefaultWatermark stores the logo used when watermark_image=true
+func handlePutDefaultWatermark(w http.ResponseWriter, r *http.Request) {
+	owner, ok := apiKeyOwner(r)
+	if !ok {
+		http.Error(w, "Valid X-API-Key required", http.StatusUnauthorized)
+		return
+	}
+
+	r.ParseMultipartForm(MaxWatermarkSize)
+	file, _, err := r.FormFile("watermark")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	if err := saveWatermarkImage(file, defaultsStore.WatermarkPath(owner)); err != nil {
+		http.Error(w, "Invalid watermark: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestDefaultsStoreUpdate(t *testing.T) {
+	store := NewDefaultsStore(filepath.Join(t.TempDir(), "defaults.json"))
+
+	if err := store.Update("owner", map[string]string{"format": "mkv", "quality": "high"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Update("owner", map[string]string{"quality": "", "mute": "true"}); err != nil {
+		t.Fatal(err)
+	}
+	got := store.Get("owner")
+	if len(got) != 2 || got["format"] != "mkv" || got["mute"] != "true" {
+		t.Errorf("Get() = %v, want format=mkv mute=true", got)
+	}
+
+	if err := store.Update("owner", map[string]string{"bogus": "1"}); err == nil {
+		t.Error("Update() accepted an unknown option")
+	}
+	if err := store.Update("owner", map[string]string{"quality": "ultra"}); err == nil {
+		t.Error("Update() accepted an invalid value")
+	}
+	if got := store.Get("owner"); len(got) != 2 {
+		t.Errorf("a failed Update() changed the defaults: %v", got)
+	}
+
+	reloaded := NewDefaultsStore(store.path)
+	if got := reloaded.Get("owner"); got["format"] != "mkv" {
+		t.Errorf("reloaded defaults = %v", got)
+	}
+}
+
+func TestDefaultsStoreConcurrentUpdates(t *testing.T) {
+	store := NewDefaultsStore(filepath.Join(t.TempDir(), "defaults.json"))
+	keys := []string{"format", "quality", "mute", "watermark_text"}
+	values := []string{"mkv", "low", "true", "hello"}
+
+	var wg sync.WaitGroup
+	for i := range keys {
+		wg.Add(1)
+		go func(key, value string) {
+			defer wg.Done()
+			if err := store.Update("owner", map[string]string{key: value}); err != nil {
+				t.Error(err)
+			}
+		}(keys[i], values[i])
+	}
+	wg.Wait()
+
+	got := store.Get("owner")
+	for i, key := range keys {
+		if got[key] != values[i] {
+			t.Errorf("lost update: %s = %q, want %q (all: %s)", key, got[key], values[i], fmt.Sprint(got))
+		}
+	}
+}
@@ -0,0 +1,485 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Failure reasons recorded on conversion attempts
+const (
+	FailureCorruptInput     = "corrupt_input"
+	FailureUnsupportedCodec = "unsupported_codec"
+	FailureOutOfDisk        = "out_of_disk"
+	FailureTimeout          = "timeout"
+	FailureKilled           = "killed"
+	FailureUnknown          = "unknown"
+)
+
+// DefaultConversionChain is used when CONVERSION_STRATEGIES is not set.
+// copy is skipped unless the probed codecs fit the output container.
+const DefaultConversionChain = "copy,fast,safe,software"
+
+// mp4CopyCodecs can be stream copied into MP4 and still play everywhere
+var mp4CopyCodecs = map[string]bool{
+	"h264": true,
+	"hevc": true,
+	"aac":  true,
+	"mp3":  true,
+}
+
+// ConversionStrategy is one ffmpeg invocation in the fallback chain
+type ConversionStrategy struct {
+	Name        string
+	Description string
+	InputArgs   []string
+	OutputArgs  []string
+	// Run overrides the default single ffmpeg invocation when set
+	Run func(ctx context.Context, strategy ConversionStrategy, job *Job, input, output string, duration float64, progress ProgressFunc) error
+}
+
+// ConversionAttempt records the outcome of a single strategy run
+type ConversionAttempt struct {
+	Strategy  string    `json:"strategy"`
+	StartedAt time.Time `json:"started_at"`
+	Duration  float64   `json:"duration_seconds"`
+	Reason    string    `json:"reason,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// FFmpegError wraps a failed ffmpeg run with its classified reason
+type FFmpegError struct {
+	Reason string
+	Err    error
+	Stderr string
+}
+
+func (e *FFmpegError) Error() string {
+	if line := lastStderrLine(e.Stderr); line != "" {
+		return fmt.Sprintf("%s: %s", e.Reason, line)
+	}
+	return fmt.Sprintf("%s: %v", e.Reason, e.Err)
+}
+
+func (e *FFmpegError) Unwrap() error {
+	return e.Err
+}
+
+var conversionStrategies = map[string]ConversionStrategy{
+	"copy": {
+		Name:        "copy",
+		Description: "stream copy",
+		OutputArgs: []string{
+			"-c", "copy",
+		},
+	},
+	"fast": {
+		Name:        "fast",
+		Description: "fast encode",
+		OutputArgs: []string{
+			"-threads", "2",
+			"-c:v", "libx264",
+			"-preset", "ultrafast",
+			"-crf", "28",
+			"-c:a", "copy",
+		},
+	},
+	"safe": {
+		Name:        "safe",
+		Description: "safe encode",
+		OutputArgs: []string{
+			"-c:v", "libx264",
+			"-preset", "veryfast",
+			"-crf", "28",
+			"-c:a", "aac",
+			"-b:a", "128k",
+			"-ar", "44100",
+		},
+	},
+	"software": {
+		Name:        "software",
+		Description: "software-only, error tolerant",
+		InputArgs: []string{
+			"-hwaccel", "none",
+			"-err_detect", "ignore_err",
+			"-fflags", "+discardcorrupt+genpts",
+		},
+		OutputArgs: []string{
+			"-c:v", "libx264",
+			"-preset", "veryfast",
+			"-crf", "28",
+			"-pix_fmt", "yuv420p",
+			"-c:a", "aac",
+			"-b:a", "128k",
+			"-ac", "2",
+			"-ar", "44100",
+		},
+	},
+}
+
+var conversionChain []ConversionStrategy
+
+// loadConversionChain reads the strategy order from CONVERSION_STRATEGIES
+func loadConversionChain() []ConversionStrategy {
+	spec := os.Getenv("CONVERSION_STRATEGIES")
+	if spec == "" {
+		spec = DefaultConversionChain
+	}
+
+	chain := make([]ConversionStrategy, 0)
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(strings.ToLower(name))
+		if name == "" {
+			continue
+		}
+		strategy, ok := conversionStrategies[name]
+		if !ok {
+			log.Printf("Warning: unknown conversion strategy %q ignored", name)
+			continue
+		}
+		chain = append(chain, strategy)
+	}
+
+	if len(chain) == 0 {
+		log.Printf("Warning: no valid conversion strategies, using %s", DefaultConversionChain)
+		for _, name := range strings.Split(DefaultConversionChain, ",") {
+			chain = append(chain, conversionStrategies[name])
+		}
+	}
+
+	return chain
+}
+
+// runConversionChain tries each strategy in order until one succeeds
+func runConversionChain(ctx context.Context, job *Job, input, output string, duration float64, progress ProgressFunc) error {
+	var lastErr error
+
+	chain := conversionChain
+	if job.Options.TargetSizeMB > 0 {
+		chain = twoPassChain
+	} else if job.Options.IsStreaming() {
+		chain = packagingChain
+	}
+
+	attempted := 0
+	for _, strategy := range chain {
+		if !strategy.usable(job) {
+			continue
+		}
+
+		queue.mu.Lock()
+		job.Strategy = strategy.Name
+		queue.mu.Unlock()
+		progress(ProgressStats{})
+
+		if attempted > 0 {
+			log.Printf("Job %s: retrying with %s", job.ID, strategy.Description)
+		}
+		attempted++
+
+		attempt := ConversionAttempt{
+			Strategy:  strategy.Name,
+			StartedAt: time.Now(),
+		}
+		var err error
+		if strategy.Run != nil {
+			err = strategy.Run(ctx, strategy, job, input, output, duration, progress)
+		} else {
+			args := buildFFmpegArgs(strategy, job, input, output)
+			err = convertVideoWithProgress(ctx, args, duration, progress)
+		}
+		attempt.Duration = time.Since(attempt.StartedAt).Seconds()
+
+		if err != nil {
+			reason := FailureUnknown
+			var ffErr *FFmpegError
+			if errors.As(err, &ffErr) {
+				reason = ffErr.Reason
+			}
+			attempt.Reason = reason
+			attempt.Error = err.Error()
+		}
+
+		queue.mu.Lock()
+		job.Attempts = append(job.Attempts, attempt)
+		queue.mu.Unlock()
+
+		if err == nil {
+			return nil
+		}
+
+		log.Printf("Job %s: %s failed (%s): %v", job.ID, strategy.Name, attempt.Reason, err)
+		os.Remove(output)
+		lastErr = err
+
+		// No point trying further strategies for these
+		if attempt.Reason == FailureTimeout || attempt.Reason == FailureKilled || attempt.Reason == FailureOutOfDisk {
+			break
+		}
+	}
+
+	if attempted == 0 {
+		return fmt.Errorf("no configured conversion strategy supports these options")
+	}
+	return lastErr
+}
+
+// buildFFmpegArgs assembles the full ffmpeg command line for a strategy
+func buildFFmpegArgs(strategy ConversionStrategy, job *Job, input, output string) []string {
+	args := jobInputArgs(strategy, job, input)
+	args = append(args, strategy.OutputArgs...)
+	args = append(args, job.Options.qualityArgs()...)
+	args = append(args, jobOutputArgs(job)...)
+	args = append(args, containerArgs(job.Options)...)
+	args = append(args,
+		"-max_muxing_queue_size", "9999",
+		"-y", output)
+	return args
+}
+
+// jobInputArgs returns everything up to and including the inputs
+func jobInputArgs(strategy ConversionStrategy, job *Job, input string) []string {
+	args := make([]string, 0)
+	args = append(args, strategy.InputArgs...)
+	args = append(args, job.Options.inputArgs()...)
+	args = append(args, "-i", input)
+	args = append(args, sidecarInputArgs(job)...)
+	args = append(args, watermarkInputArgs(job)...)
+	return args
+}
+
+// jobOutputArgs returns trim, filter, stream mapping and metadata options.
+// They come after the strategy's codec options so -c:s overrides -c copy.
+func jobOutputArgs(job *Job) []string {
+	args := make([]string, 0)
+	args = append(args, job.Options.trimArgs()...)
+	filterArgs, videoMap := videoFilterArgs(job)
+	args = append(args, filterArgs...)
+	args = append(args, streamArgs(job, videoMap)...)
+	args = append(args, metadataArgs(job.Options)...)
+	return args
+}
+
+// qualityArgs overrides the strategy's CRF; the last -crf wins. Two-pass
+// jobs pick their bitrate from the target size instead.
+func (o ConversionOptions) qualityArgs() []string {
+	if o.Quality == "" {
+		return nil
+	}
+	return []string{"-crf", qualityCRF[o.Quality]}
+}
+
+// usable reports whether the strategy can honour the job options
+func (s ConversionStrategy) usable(job *Job) bool {
+	if s.Name != "copy" {
+		return true
+	}
+	// Stream copy can only cut on keyframes and cannot filter
+	opts := job.Options
+	if opts.TrimMode == TrimModeAccurate || opts.HasVideoFilters() || opts.Quality != "" {
+		return false
+	}
+	return copyableCodecs(job)
+}
+
+// copyableCodecs reports whether the probed streams fit the output
+// container as they are. Unprobed inputs are always encoded.
+func copyableCodecs(job *Job) bool {
+	if job.InputInfo == nil || job.InputInfo.VideoStream() == nil {
+		return false
+	}
+	if job.Options.Format == FormatMKV {
+		return true
+	}
+	if !mp4CopyCodecs[job.InputInfo.VideoStream().Codec] {
+		return false
+	}
+	if job.Options.Mute {
+		return true
+	}
+	for _, stream := range job.InputInfo.Streams {
+		if stream.Type == "audio" && !mp4CopyCodecs[stream.Codec] {
+			return false
+		}
+	}
+	return true
+}
+
+// convertVideoWithProgress runs ffmpeg with args, reporting progress against
+// duration (the length of the output, not necessarily of the input)
+func convertVideoWithProgress(ctx context.Context, ffmpegArgs []string, duration float64, progress ProgressFunc) error {
+	args := []string{"-n", "10", "ffmpeg", "-progress", "pipe:1", "-nostats"}
+	args = append(args, ffmpegArgs...)
+
+	cmd := exec.CommandContext(ctx, "nice", args...)
+
+	stderr := &tailBuffer{limit: 4096}
+	cmd.Stderr = stderr
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	// Each block of key=value lines ends with progress=continue or progress=end
+	scanner := bufio.NewScanner(stdout)
+	var stats ProgressStats
+
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if !ok {
+			continue
+		}
+
+		if key == "progress" {
+			stats.finish(duration, value == "end")
+			progress(stats)
+		} else {
+			stats.parseProgressLine(key, value)
+		}
+	}
+
+	if err := cmd.Wait(); err != nil {
+		return &FFmpegError{
+			Reason: classifyFFmpegError(ctx, err, stderr.String()),
+			Err:    err,
+			Stderr: stderr.String(),
+		}
+	}
+
+	return nil
+}
+
+// classifyFFmpegError maps a failed run to one of the Failure* reasons
+func classifyFFmpegError(ctx context.Context, err error, stderr string) string {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return FailureTimeout
+	}
+
+	lower := strings.ToLower(stderr)
+	if strings.Contains(lower, "no space left on device") || strings.Contains(lower, "disk quota exceeded") {
+		return FailureOutOfDisk
+	}
+
+	if ctx.Err() != nil {
+		return FailureKilled
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			return FailureKilled
+		}
+	}
+
+	for _, marker := range []string{
+		"unknown encoder",
+		"encoder not found",
+		"decoder not found",
+		"could not find tag for codec",
+		"not currently supported in container",
+		"codec not currently supported",
+		"unsupported codec",
+		"no decoder for",
+	} {
+		if strings.Contains(lower, marker) {
+			return FailureUnsupportedCodec
+		}
+	}
+
+	for _, marker := range []string{
+		"invalid data found when processing input",
+		"ebml header parsing failed",
+		"moov atom not found",
+		"error while decoding",
+		"corrupt",
+		"truncat",
+		"invalid as first byte",
+		"header missing",
+	} {
+		if strings.Contains(lower, marker) {
+			return FailureCorruptInput
+		}
+	}
+
+	return FailureUnknown
+}
+
+// failureMessage turns a failure reason into text suitable for users
+func failureMessage(reason string) string {
+	switch reason {
+	case FailureCorruptInput:
+		return "The input file appears to be corrupt or incomplete"
+	case FailureUnsupportedCodec:
+		return "The input uses a codec that cannot be converted"
+	case FailureOutOfDisk:
+		return "The server ran out of disk space"
+	case FailureTimeout:
+		return "The conversion took too long and was stopped"
+	case FailureKilled:
+		return "The conversion process was killed"
+	default:
+		return "The conversion failed"
+	}
+}
+
+// tailBuffer keeps the last limit bytes written to it
+type tailBuffer struct {
+	mu    sync.Mutex
+	limit int
+	data  []byte
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.data = append(b.data, p...)
+	if len(b.data) > b.limit {
+		b.data = b.data[len(b.data)-b.limit:]
+	}
+	return len(p), nil
+}
+
+func (b *tailBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return string(b.data)
+}
+
+func lastStderrLine(stderr string) string {
+	lines := strings.Split(strings.TrimSpace(stderr), "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		if line := strings.TrimSpace(lines[i]); line != "" {
+			return line
+		}
+	}
+	return ""
+}
+
+func strategyNames(chain []ConversionStrategy) string {
+	names := make([]string, len(chain))
+	for i, strategy := range chain {
+		names[i] = strategy.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+func attemptedStrategies(job *Job) string {
+	names := make([]string, len(job.Attempts))
+	for i, attempt := range job.Attempts {
+		names[i] = attempt.Strategy
+	}
+	return strings.Join(names, ", ")
+}
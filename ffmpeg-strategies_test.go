@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestClassifyFFmpegError(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	failed := errors.New("exit status 1")
+
+	tests := []struct {
+		name   string
+		ctx    context.Context
+		stderr string
+		want   string
+	}{
+		{"timeout", expired, "", FailureTimeout},
+		{"timeout wins over disk", expired, "No space left on device", FailureTimeout},
+		{"disk full", context.Background(), "av_interleaved_write_frame(): No space left on device", FailureOutOfDisk},
+		{"quota", context.Background(), "Disk quota exceeded", FailureOutOfDisk},
+		{"cancelled", cancelled, "Invalid data found when processing input", FailureKilled},
+		{"encoder", context.Background(), "Unknown encoder 'libx264'", FailureUnsupportedCodec},
+		{"container", context.Background(), "codec not currently supported in container", FailureUnsupportedCodec},
+		{"bad input", context.Background(), "input.webm: Invalid data found when processing input", FailureCorruptInput},
+		{"ebml", context.Background(), "EBML header parsing failed", FailureCorruptInput},
+		{"truncated", context.Background(), "File ended prematurely, truncated", FailureCorruptInput},
+		{"other", context.Background(), "Conversion failed!", FailureUnknown},
+		{"empty", context.Background(), "", FailureUnknown},
+	}
+
+	for _, tt := range tests {
+		if got := classifyFFmpegError(tt.ctx, failed, tt.stderr); got != tt.want {
+			t.Errorf("%s: classifyFFmpegError() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestQualityArgs(t *testing.T) {
+	if args := (ConversionOptions{}).qualityArgs(); args != nil {
+		t.Errorf("no preset gave %q, want nil", args)
+	}
+	for quality, crf := range map[string]string{QualityLow: "32", QualityMedium: "28", QualityHigh: "23"} {
+		args := ConversionOptions{Quality: quality}.qualityArgs()
+		if len(args) != 2 || args[0] != "-crf" || args[1] != crf {
+			t.Errorf("qualityArgs(%s) = %q, want -crf %s", quality, args, crf)
+		}
+	}
+
+}
+
+func TestCopyStrategyUsable(t *testing.T) {
+	probed := func(codecs ...string) *MediaInfo {
+		info := &MediaInfo{}
+		for i, codec := range codecs {
+			kind := "audio"
+			if i == 0 {
+				kind = "video"
+			}
+			info.Streams = append(info.Streams, StreamInfo{Index: i, Type: kind, Codec: codec})
+		}
+		return info
+	}
+
+	tests := []struct {
+		name string
+		job  *Job
+		want bool
+	}{
+		{"h264 and aac into mp4", &Job{InputInfo: probed("h264", "aac"), Options: ConversionOptions{Format: FormatMP4}}, true},
+		{"vp9 into mp4", &Job{InputInfo: probed("vp9", "opus"), Options: ConversionOptions{Format: FormatMP4}}, false},
+		{"opus into mp4", &Job{InputInfo: probed("h264", "opus"), Options: ConversionOptions{Format: FormatMP4}}, false},
+		{"opus dropped by mute", &Job{InputInfo: probed("h264", "opus"), Options: ConversionOptions{Format: FormatMP4, Mute: true}}, true},
+		{"vp9 into mkv", &Job{InputInfo: probed("vp9", "opus"), Options: ConversionOptions{Format: FormatMKV}}, true},
+		{"not probed", &Job{Options: ConversionOptions{Format: FormatMKV}}, false},
+		{"quality preset", &Job{InputInfo: probed("h264"), Options: ConversionOptions{Quality: QualityHigh}}, false},
+		{"accurate trim", &Job{InputInfo: probed("h264"), Options: ConversionOptions{TrimMode: TrimModeAccurate}}, false},
+		{"filters", &Job{InputInfo: probed("h264"), Options: ConversionOptions{Rotate: 90}}, false},
+	}
+
+	copyStrategy := conversionStrategies["copy"]
+	for _, tt := range tests {
+		if got := copyStrategy.usable(tt.job); got != tt.want {
+			t.Errorf("%s: usable() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	if !conversionStrategies["fast"].usable(&Job{}) {
+		t.Error("encoding strategies must always be usable")
+	}
+}
@@ -9,4 +9,4 @@ require (
 	github.com/rs/cors v1.11.1
 )
 
-require github.com/go-telegram-bot-api/telegram-bot-api/v5 v5.5.1 // indirect
+require github.com/go-telegram-bot-api/telegram-bot-api/v5 v5.5.1
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// cancelJob stops a job that has not finished yet. Queued jobs are removed
+// from the queue right away; downloading and processing jobs have their
+// context cancelled and are marked cancelled when their goroutine unwinds.
+// It returns false if the job already finished.
+func cancelJob(job *Job) bool {
+	queue.mu.Lock()
+
+	switch job.Status {
+	case "queued":
+		for i, j := range queue.jobs {
+			if j == job {
+				queue.jobs = append(queue.jobs[:i], queue.jobs[i+1:]...)
+				break
+			}
+		}
+		for i, j := range queue.jobs {
+			j.QueuePos = i + 1
+		}
+		job.QueuePos = 0
+		finishCancelledJobLocked(job)
+		queue.mu.Unlock()
+
+		broadcastUpdate(job)
+		return true
+
+	case "downloading", "processing":
+		job.cancelRequested = true
+		cancel := job.cancel
+		queue.mu.Unlock()
+
+		if cancel != nil {
+			cancel()
+		}
+		return true
+	}
+
+	queue.mu.Unlock()
+	return false
+}
+
+// finishCancelledJobLocked marks a job cancelled and keeps it for a retry.
+// Caller must hold queue.mu.
+func finishCancelledJobLocked(job *Job) {
+	job.Status = "cancelled"
+	job.Error = "Cancelled"
+	job.FailureReason = ""
+	job.CompletedAt = time.Now()
+	keepFailedJobLocked(job)
+}
+
+// removeJobOutputs deletes the output, stream folder and preview assets
+func removeJobOutputs(job *Job) {
+	os.Remove(mediaOutputPath(job))
+	os.RemoveAll(streamDir(job))
+	os.RemoveAll(assetsDir(job))
+}
+
+func handleCancelJob(w http.ResponseWriter, r *http.Request) {
+	jobID := mux.Vars(r)["id"]
+
+	queue.mu.RLock()
+	job, exists := findJobLocked(jobID)
+	queue.mu.RUnlock()
+
+	if !exists {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+
+	if !cancelJob(job) {
+		http.Error(w, "Job already finished", http.StatusConflict)
+		return
+	}
+
+	queue.mu.RLock()
+	defer queue.mu.RUnlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCancelProcessingJob(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	job := &Job{ID: "cancel-processing", Status: "processing", cancel: cancel}
+
+	if !cancelJob(job) {
+		t.Fatal("cancelJob() = false for a processing job")
+	}
+	if ctx.Err() == nil {
+		t.Error("cancelJob() did not cancel the job's context")
+	}
+	if !job.cancelRequested {
+		t.Error("cancelJob() did not record the request")
+	}
+}
+
+func TestCancelFinishedJob(t *testing.T) {
+	for _, status := range []string{"completed", "failed", "cancelled"} {
+		if cancelJob(&Job{ID: "cancel-" + status, Status: status}) {
+			t.Errorf("cancelJob() = true for a %s job", status)
+		}
+	}
+}
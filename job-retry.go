@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// FailedJobTTL is how long failed and cancelled jobs keep their inputs for a retry
+const FailedJobTTL = 1 * time.Hour
+
+// keepFailedJobLocked holds a failed or cancelled job until FailedJobTTL,
+// then removes it and its files unless it was retried in the meantime.
+// Caller must hold queue.mu.
+func keepFailedJobLocked(job *Job) {
+	queue.failed[job.ID] = job
+	finishedAt := job.CompletedAt
+
+	time.AfterFunc(FailedJobTTL, func() {
+		queue.mu.Lock()
+		expired := queue.failed[job.ID] == job && job.CompletedAt.Equal(finishedAt)
+		if expired {
+			delete(queue.failed, job.ID)
+		}
+		queue.mu.Unlock()
+
+		if expired {
+			removeJobOutputs(job)
+			removeJobInputs(job, sourcePath(job))
+		}
+	})
+}
+
+// retryJob queues a failed or cancelled job again with the same input and options
+func retryJob(job *Job) error {
+	queue.mu.Lock()
+
+	if queue.failed[job.ID] != job {
+		queue.mu.Unlock()
+		return fmt.Errorf("only failed or cancelled jobs can be retried")
+	}
+	if !fileExists(sourcePath(job)) {
+		queue.mu.Unlock()
+		return fmt.Errorf("the uploaded file is no longer available")
+	}
+	if batch, exists := queue.batches[job.BatchID]; exists && batch.Cancelled {
+		queue.mu.Unlock()
+		return fmt.Errorf("batch was cancelled")
+	}
+
+	delete(queue.failed, job.ID)
+	removeJobOutputs(job)
+
+	job.Status = "queued"
+	job.Progress = 0
+	job.Stats = nil
+	job.StartedAt = time.Time{}
+	job.CompletedAt = time.Time{}
+	job.Error = ""
+	job.FailureReason = ""
+	job.Strategy = ""
+	job.Attempts = nil
+	job.Bitrate = nil
+	job.Previews = nil
+	job.Streaming = nil
+	job.OutputInfo = nil
+	job.Sidecars = nil
+	job.cancelRequested = false
+
+	queue.jobs = append(queue.jobs, job)
+	for i, j := range queue.jobs {
+		j.QueuePos = i + 1
+	}
+	queue.mu.Unlock()
+
+	broadcastUpdate(job)
+	return nil
+}
+
+func handleRetryJob(w http.ResponseWriter, r *http.Request) {
+	jobID := mux.Vars(r)["id"]
+
+	queue.mu.RLock()
+	job, exists := findJobLocked(jobID)
+	queue.mu.RUnlock()
+
+	if !exists {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+
+	if err := retryJob(job); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	queue.mu.RLock()
+	defer queue.mu.RUnlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
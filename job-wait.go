@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Long-poll limits
+const (
+	DefaultWaitTimeout = 30 * time.Second
+	MaxWaitTimeout     = 5 * time.Minute
+)
+
+// closedDone is returned to waiters of jobs that already finished
+var closedDone = func() chan struct{} {
+	done := make(chan struct{})
+	close(done)
+	return done
+}()
+
+// jobDoneLocked returns a channel that is closed once the job reaches a
+// terminal status. Caller must hold queue.mu for writing.
+func jobDoneLocked(job *Job) <-chan struct{} {
+	if isFinalStatus(job.Status) {
+		return closedDone
+	}
+	if job.done == nil {
+		job.done = make(chan struct{})
+	}
+	return job.done
+}
+
+// notifyJobDone wakes the job's waiters if it finished. It is called from
+// broadcastUpdate; a retried job gets a fresh channel for new waiters.
+func notifyJobDone(job *Job) {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	if isFinalStatus(job.Status) && job.done != nil {
+		close(job.done)
+		job.done = nil
+	}
+}
+
+// waitForJob blocks until the job finishes or ctx ends, reporting which
+func waitForJob(ctx context.Context, job *Job) bool {
+	queue.mu.Lock()
+	done := jobDoneLocked(job)
+	queue.mu.Unlock()
+
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// parseWaitTimeout accepts Go durations ("90s", "2m") or plain seconds
+func parseWaitTimeout(value string) (time.Duration, bool) {
+	if value == "" {
+		return DefaultWaitTimeout, true
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		seconds, err := strconv.Atoi(value)
+		if err != nil {
+			return 0, false
+		}
+		timeout = time.Duration(seconds) * time.Second
+	}
+	if timeout < 0 {
+		return 0, false
+	}
+	if timeout > MaxWaitTimeout {
+		timeout = MaxWaitTimeout
+	}
+	return timeout, true
+}
+
+// handleWaitJob serves GET /api/jobs/{id}/wait?timeout=60s. It returns the
+// job as soon as it completes, fails or is cancelled, or when the timeout
+// expires; clients check its status to tell which.
+func handleWaitJob(w http.ResponseWriter, r *http.Request) {
+	jobID := mux.Vars(r)["id"]
+
+	timeout, ok := parseWaitTimeout(r.URL.Query().Get("timeout"))
+	if !ok {
+		http.Error(w, "Invalid timeout", http.StatusBadRequest)
+		return
+	}
+
+	queue.mu.RLock()
+	job, exists := findJobLocked(jobID)
+	queue.mu.RUnlock()
+
+	if !exists {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	waitForJob(ctx, job)
+
+	if r.Context().Err() != nil {
+		return
+	}
+
+	queue.mu.RLock()
+	defer queue.mu.RUnlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
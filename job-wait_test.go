@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseWaitTimeout(t *testing.T) {
+	tests := []struct {
+		in     string
+		want   time.Duration
+		wantOK bool
+	}{
+		{"", DefaultWaitTimeout, true},
+		{"90s", 90 * time.Second, true},
+		{"2m", 2 * time.Minute, true},
+		{"45", 45 * time.Second, true},
+		{"0", 0, true},
+		{"1h", MaxWaitTimeout, true},
+		{"600", MaxWaitTimeout, true},
+		{"-5s", 0, false},
+		{"-5", 0, false},
+		{"soon", 0, false},
+		{"1.5", 0, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := parseWaitTimeout(tt.in)
+		if ok != tt.wantOK || got != tt.want {
+			t.Errorf("parseWaitTimeout(%q) = %v, %v; want %v, %v", tt.in, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
@@ -1,18 +1,15 @@
 package main
 
 import (
-	"archive/zip"
-	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -31,6 +28,7 @@ const (
 	UploadDir     = "./web-uploads"
 	OutputDir     = "./web-output"
 	TempDir       = "./web-temp"
+	DataDir       = "./web-data"                 // persisted settings such as per-owner defaults
 	MaxCPUUsage   = 70                 // Maximum CPU usage percentage
 )
 
@@ -39,16 +37,53 @@ type Job struct {
 	FileName    string    `json:"filename"`
 	FileSize    int64     `json:"filesize"`
 	OutputName  string    `json:"output_name"`
-	Status      string    `json:"status"` // queued, processing, completed, failed
+	Status      string    `json:"status"` // downloading, queued, processing, completed, failed, cancelled
 	Progress    int       `json:"progress"`
+	Stats       *ProgressStats `json:"stats,omitempty"` // fps, speed, ETA of the running strategy
 	QueuePos    int       `json:"queue_position"`
 	StartedAt   time.Time `json:"started_at"`
 	CompletedAt time.Time `json:"completed_at,omitempty"`
 	CreatedAt   time.Time `json:"created_at"`
 	Error       string    `json:"error,omitempty"`
+	Options     ConversionOptions `json:"options"`
+	Bitrate     *BitratePlan      `json:"bitrate,omitempty"`
+	Previews    *PreviewAssets    `json:"previews,omitempty"`
+	Streaming   *StreamingOutput  `json:"streaming,omitempty"`
+	// Original upload kept for side-by-side playback, see retainSources
+	SourceAvailable bool `json:"source_available,omitempty"`
+	// Probed media details, served by /api/jobs/{id}/info
+	InputInfo  *MediaInfo `json:"-"`
+	OutputInfo *MediaInfo `json:"-"`
+	// Extracted subtitle files shipped next to the output
+	Sidecars         []string `json:"sidecars,omitempty"`
+	SubtitleBurnPath string   `json:"-"`
+	// Uploaded subtitle sidecar
+	SubtitleFile      string `json:"subtitle_file,omitempty"`
+	SubtitleInputPath string `json:"-"`
+	// Logo for watermark_image (per-job upload or the owner's default)
+	WatermarkPath string `json:"-"`
+	// API key or Telegram chat that submitted the job, see apiKeyOwner
+	Owner string `json:"-"`
+	// Conversion fallback chain
+	Strategy      string              `json:"strategy,omitempty"`
+	Attempts      []ConversionAttempt `json:"attempts,omitempty"`
+	FailureReason string              `json:"failure_reason,omitempty"`
+	// Batch the job was submitted in, see batches.go
+	BatchID string `json:"batch_id,omitempty"`
+	// Called on status changes, see notifyWebhooks
+	WebhookURL    string `json:"webhook_url,omitempty"`
+	WebhookSecret string `json:"-"`
+	webhookStatus string
 	// For Telegram jobs
 	TelegramChatID int64 `json:"-"`
 	TelegramMsgID  int   `json:"-"`
+	TelegramError  string `json:"telegram_error,omitempty"` // last failed send of the result
+	telegramStatus string // last status reported to the chat, see notifyTelegram
+	// Cancellation, see cancelJob
+	cancel          context.CancelFunc
+	cancelRequested bool
+	// Closed when the job finishes, see jobDoneLocked
+	done chan struct{}
 }
 
 type Queue struct {
@@ -56,7 +91,8 @@ type Queue struct {
 	jobs       []*Job
 	processing map[string]*Job
 	completed  map[string]*Job
-	clients    map[*websocket.Conn]bool
+	failed     map[string]*Job // failed and cancelled, kept for retries
+	batches    map[string]*Batch
 }
 
 var (
@@ -64,7 +100,8 @@ var (
 		jobs:       make([]*Job, 0),
 		processing: make(map[string]*Job),
 		completed:  make(map[string]*Job),
-		clients:    make(map[*websocket.Conn]bool),
+		failed:     make(map[string]*Job),
+		batches:    make(map[string]*Batch),
 	}
 	upgrader = websocket.Upgrader{
 		CheckOrigin: func(r *http.Request) bool { return true },
@@ -77,30 +114,79 @@ func main() {
 	os.MkdirAll(UploadDir, 0755)
 	os.MkdirAll(OutputDir, 0755)
 	os.MkdirAll(TempDir, 0755)
+	os.MkdirAll(DataDir, 0755)
 	os.MkdirAll("web", 0755)
 
 	// Generate favicon
 	GenerateFavicon()
+	
+	// Load conversion fallback chain
+	conversionChain = loadConversionChain()
+	log.Printf("Conversion strategies: %s", strategyNames(conversionChain))
+
+	// API keys and per-owner defaults
+	apiKeys = loadAPIKeys()
+	defaultsStore = NewDefaultsStore(filepath.Join(DataDir, "defaults.json"))
+	telegramChats = NewTelegramChatStore(filepath.Join(DataDir, "telegram-chats.json"))
+	retainSources = loadRetainSources()
+	downloadSigningKey = loadDownloadSigningKey()
+	webhookStore = NewWebhookStore(filepath.Join(DataDir, "webhooks.json"))
+	webhookSecret = loadWebhookSecret()
 
 	// Initialize Telegram bot if token provided
 	telegramToken := os.Getenv("TELEGRAM_BOT_TOKEN")
+	loadTelegramConfig()
 	if telegramToken != "" {
 		initTelegramBot(telegramToken)
 	}
 
-	// Start queue processor
+	// Start queue processor and WebSocket hub
 	go queueProcessor()
+	go hub.run()
 
 	// HTTP routes
 	router := mux.NewRouter()
 	
 	// API routes
 	router.HandleFunc("/api/upload", handleUpload).Methods("POST")
+	router.HandleFunc("/api/probe", handleProbe).Methods("POST")
 	router.HandleFunc("/api/jobs", handleGetJobs).Methods("GET")
+	router.HandleFunc("/api/jobs/download-all", handleSignedDownloadAll).Methods("GET")
+	router.HandleFunc("/api/jobs/download-all", handleDownloadAll).Methods("POST")
+	router.HandleFunc("/api/jobs/download-all/sign", handleSignDownloadAll).Methods("POST")
 	router.HandleFunc("/api/jobs/{id}", handleGetJob).Methods("GET")
+	router.HandleFunc("/api/jobs/{id}/cancel", handleCancelJob).Methods("POST")
+	router.HandleFunc("/api/jobs/{id}/retry", handleRetryJob).Methods("POST")
+	router.HandleFunc("/api/jobs/{id}/events", handleJobEvents).Methods("GET")
+	router.HandleFunc("/api/jobs/{id}/wait", handleWaitJob).Methods("GET")
+	router.HandleFunc("/api/jobs/{id}/info", handleJobInfo).Methods("GET")
 	router.HandleFunc("/api/jobs/{id}/download", handleDownload).Methods("GET")
-	router.HandleFunc("/api/jobs/download-all", handleDownloadAll).Methods("POST")
+	router.HandleFunc("/api/jobs/{id}/download/signed", handleSignedJobDownload).Methods("GET")
+	router.HandleFunc("/api/jobs/{id}/stream", handleStream).Methods("GET", "HEAD")
+	router.HandleFunc("/api/jobs/{id}/source", handleSource).Methods("GET", "HEAD")
+	router.HandleFunc("/api/jobs/{id}/thumbnail", handleThumbnail).Methods("GET")
+	router.HandleFunc("/api/jobs/{id}/preview", handlePreview).Methods("GET")
+	router.HandleFunc("/api/jobs/{id}/sprite", handleSprite).Methods("GET")
+	router.HandleFunc("/api/jobs/{id}/sprite.vtt", handleSpriteVTT).Methods("GET")
+	router.HandleFunc("/api/jobs/{id}/sidecars/{name}", handleSidecar).Methods("GET")
+	router.HandleFunc("/api/events", handleEvents).Methods("GET")
+	router.HandleFunc("/api/batches", handleCreateBatch).Methods("POST")
+	router.HandleFunc("/api/batches/{id}", handleGetBatch).Methods("GET")
+	router.HandleFunc("/api/batches/{id}/files", handleBatchUpload).Methods("POST")
+	router.HandleFunc("/api/batches/{id}/urls", handleBatchURLs).Methods("POST")
+	router.HandleFunc("/api/batches/{id}/cancel", handleCancelBatch).Methods("POST")
+	router.HandleFunc("/api/batches/{id}/download", handleBatchDownload).Methods("GET")
+	router.HandleFunc("/api/webhook", handleGetWebhook).Methods("GET")
+	router.HandleFunc("/api/webhook", handlePutWebhook).Methods("PUT")
+	router.HandleFunc("/api/webhook", handleDeleteWebhook).Methods("DELETE")
+	router.HandleFunc("/api/webhooks/deliveries", handleListDeliveries).Methods("GET")
+	router.HandleFunc("/api/webhooks/deliveries/{id}", handleGetDelivery).Methods("GET")
+	router.HandleFunc("/api/webhooks/deliveries/{id}/replay", handleReplayDelivery).Methods("POST")
+	router.HandleFunc("/api/defaults", handleGetDefaults).Methods("GET")
+	router.HandleFunc("/api/defaults", handlePutDefaults).Methods("PUT")
+	router.HandleFunc("/api/defaults/watermark", handlePutDefaultWatermark).Methods("PUT", "POST")
 	router.HandleFunc("/ws", handleWebSocket)
+	router.PathPrefix("/streams/{id}/").HandlerFunc(handleStreamFile).Methods("GET", "HEAD")
 	
 	// Static files
 	router.PathPrefix("/").Handler(http.FileServer(http.Dir("./web/")))
@@ -108,7 +194,7 @@ func main() {
 	// CORS middleware
 	handler := cors.New(cors.Options{
 		AllowedOrigins:   []string{"*"},
-		AllowedMethods:   []string{"GET", "POST", "OPTIONS"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
 		AllowedHeaders:   []string{"*"},
 		AllowCredentials: true,
 	}).Handler(router)
@@ -124,13 +210,15 @@ func main() {
 // Telegram Bot Functions
 func initTelegramBot(token string) {
 	var err error
-	telegramBot, err = tgbotapi.NewBotAPI(token)
+	telegramBot, err = newTelegramBot(token)
 	if err != nil {
 		log.Printf("Failed to initialize Telegram bot: %v", err)
 		return
 	}
 	
 	log.Printf("Telegram bot initialized: @%s", telegramBot.Self.UserName)
+	registerTelegramCommands()
+	go runTelegramNotifier()
 	go handleTelegramUpdates()
 }
 
@@ -141,6 +229,10 @@ func handleTelegramUpdates() {
 	updates := telegramBot.GetUpdatesChan(u)
 	
 	for update := range updates {
+		if update.CallbackQuery != nil {
+			go handleTelegramCallback(update.CallbackQuery)
+			continue
+		}
 		if update.Message == nil {
 			continue
 		}
@@ -150,172 +242,28 @@ func handleTelegramUpdates() {
 }
 
 func processTelegramMessage(message *tgbotapi.Message) {
-	chatID := message.Chat.ID
-	
 	// Handle commands
 	if message.IsCommand() {
-		switch message.Command() {
-		case "start":
-			text := "🎥 *WebM to MP4 Converter*\n\nSend me a WebM file and I'll convert it to MP4!"
-			msg := tgbotapi.NewMessage(chatID, text)
-			msg.ParseMode = "Markdown"
-			telegramBot.Send(msg)
-		case "status":
-			queue.mu.RLock()
-			q := len(queue.jobs)
-			p := len(queue.processing)
-			queue.mu.RUnlock()
-			text := fmt.Sprintf("📊 Queue: %d | Processing: %d/%d", q, p, MaxConcurrent)
-			telegramBot.Send(tgbotapi.NewMessage(chatID, text))
-		default:
-			telegramBot.Send(tgbotapi.NewMessage(chatID, "Unknown command"))
-		}
-		return
-	}
-	
-	// Handle document
-	if message.Document != nil {
-		handleTelegramDocument(message)
-	}
-}
-
-func handleTelegramDocument(message *tgbotapi.Message) {
-	chatID := message.Chat.ID
-	doc := message.Document
-	
-	// Check file extension
-	if !strings.HasSuffix(strings.ToLower(doc.FileName), ".webm") {
-		telegramBot.Send(tgbotapi.NewMessage(chatID, "❌ Please send a WebM file"))
+		handleTelegramCommand(message)
 		return
 	}
 	
-	// Check size
-	if doc.FileSize > MaxFileSize {
-		telegramBot.Send(tgbotapi.NewMessage(chatID, "❌ File too large (max 100MB)"))
-		return
-	}
-	
-	// Send processing message
-	msg := tgbotapi.NewMessage(chatID, "⏳ Processing...")
-	sentMsg, _ := telegramBot.Send(msg)
-	
-	// Download file
-	file, err := telegramBot.GetFile(tgbotapi.FileConfig{FileID: doc.FileID})
-	if err != nil {
-		editMsg := tgbotapi.NewEditMessageText(chatID, sentMsg.MessageID, "❌ Failed to download")
-		telegramBot.Send(editMsg)
-		return
-	}
-	
-	// Download to temp
-	tempPath := filepath.Join(TempDir, fmt.Sprintf("tg_%d_%s", chatID, doc.FileName))
-	fileURL := file.Link(telegramBot.Token)
-	
-	if err := downloadFileFromURL(fileURL, tempPath); err != nil {
-		editMsg := tgbotapi.NewEditMessageText(chatID, sentMsg.MessageID, "❌ Download failed")
-		telegramBot.Send(editMsg)
-		return
-	}
-	
-	// Create job
-	job := &Job{
-		ID:             uuid.New().String(),
-		FileName:       doc.FileName,
-		FileSize:       int64(doc.FileSize),
-		OutputName:     strings.TrimSuffix(doc.FileName, ".webm") + ".mp4",
-		Status:         "queued",
-		CreatedAt:      time.Now(),
-		TelegramChatID: chatID,
-		TelegramMsgID:  sentMsg.MessageID,
-	}
-	
-	// Move to upload dir
-	uploadPath := filepath.Join(UploadDir, job.ID+"_"+job.FileName)
-	os.Rename(tempPath, uploadPath)
-	
-	// Add to queue
-	queue.mu.Lock()
-	queue.jobs = append(queue.jobs, job)
-	for i, j := range queue.jobs {
-		j.QueuePos = i + 1
-	}
-	queue.mu.Unlock()
-	
-	// Update message
-	editMsg := tgbotapi.NewEditMessageText(chatID, sentMsg.MessageID, 
-		fmt.Sprintf("📥 Added to queue #%d", job.QueuePos))
-	telegramBot.Send(editMsg)
-	
-	// Monitor job
-	go monitorTelegramJob(job)
-}
-
-func monitorTelegramJob(job *Job) {
-	ticker := time.NewTicker(2 * time.Second)
-	defer ticker.Stop()
-	
-	for range ticker.C {
-		queue.mu.RLock()
-		
-		// Check if completed
-		if completed, ok := queue.completed[job.ID]; ok {
-			queue.mu.RUnlock()
-			
-			// Send file
-			outputPath := filepath.Join(OutputDir, job.ID+"_"+job.OutputName)
-			sendTelegramFile(job.TelegramChatID, job.TelegramMsgID, outputPath, completed.OutputName)
-			return
-		}
-		
-		// Check if processing
-		if processing, ok := queue.processing[job.ID]; ok {
-			queue.mu.RUnlock()
-			
-			// Update progress
-			editMsg := tgbotapi.NewEditMessageText(job.TelegramChatID, job.TelegramMsgID,
-				fmt.Sprintf("🔄 Converting... %d%%", processing.Progress))
-			telegramBot.Send(editMsg)
-			continue
-		}
-		
-		queue.mu.RUnlock()
-		
-		// Check timeout
-		if time.Since(job.CreatedAt) > 30*time.Minute {
-			editMsg := tgbotapi.NewEditMessageText(job.TelegramChatID, job.TelegramMsgID, "❌ Timeout")
-			telegramBot.Send(editMsg)
-			return
-		}
-	}
-}
-
-func sendTelegramFile(chatID int64, msgID int, filepath, filename string) {
-	// Read file
-	data, err := os.ReadFile(filepath)
-	if err != nil {
-		editMsg := tgbotapi.NewEditMessageText(chatID, msgID, "❌ Failed to send file")
-		telegramBot.Send(editMsg)
+	// A reply to "✂️ Custom" sets the trim of the video waiting for options
+	if message.Text != "" && handleTelegramTrimReply(message) {
 		return
 	}
 	
-	// Update message
-	editMsg := tgbotapi.NewEditMessageText(chatID, msgID, "📤 Uploading...")
-	telegramBot.Send(editMsg)
-	
-	// Send document
-	doc := tgbotapi.FileBytes{
-		Name:  filename,
-		Bytes: data,
+	// A PNG captioned /watermark becomes the chat's logo; any other video
+	// attachment or link is converted
+	if message.Document != nil && strings.HasPrefix(message.Caption, "/watermark") {
+		handleTelegramWatermarkLogo(message)
+	} else if media := telegramMediaFromMessage(message); media != nil {
+		handleTelegramMedia(message, media)
+	} else if links, caption := messageURLs(message); len(links) > 0 {
+		handleTelegramLinks(message, links, caption)
+	} else {
+		rejectTelegramMessage(message)
 	}
-	
-	msg := tgbotapi.NewDocument(chatID, doc)
-	msg.Caption = "✅ Converted successfully!"
-	telegramBot.Send(msg)
-	
-	// Final update
-	editMsg = tgbotapi.NewEditMessageText(chatID, msgID, 
-		fmt.Sprintf("✅ Done! File: %s", filename))
-	telegramBot.Send(editMsg)
 }
 
 // Download helper
@@ -326,6 +274,10 @@ func downloadFileFromURL(url, filepath string) error {
 	}
 	defer resp.Body.Close()
 	
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("server returned %s", resp.Status)
+	}
+	
 	out, err := os.Create(filepath)
 	if err != nil {
 		return err
@@ -338,6 +290,11 @@ func downloadFileFromURL(url, filepath string) error {
 
 // Web Server Handlers
 func handleUpload(w http.ResponseWriter, r *http.Request) {
+	acceptUpload(w, r, nil)
+}
+
+// acceptUpload saves an uploaded file and queues it, optionally into a batch
+func acceptUpload(w http.ResponseWriter, r *http.Request, batch *Batch) {
 	r.ParseMultipartForm(MaxFileSize)
 	
 	file, header, err := r.FormFile("file")
@@ -358,6 +315,28 @@ func handleUpload(w http.ResponseWriter, r *http.Request) {
 	customName := r.FormValue("custom_name")
 	outputName := getOutputName(header.Filename, renameOption, customName)
 	
+	// Get conversion options, on top of the batch options and the API key's defaults
+	owner, _ := apiKeyOwner(r)
+	get := r.FormValue
+	if batch != nil {
+		owner = batch.Owner
+		get = withDefaults(r.FormValue, batchDefaults(batch))
+	} else if owner != "" {
+		get = withDefaults(r.FormValue, defaultsStore.Get(owner))
+	}
+	options, err := parseConversionOptions(get)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	outputName = withOutputExtension(outputName, options)
+	
+	webhookURL, err := parseWebhookURL(r.FormValue("webhook_url"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	
 	// Create job
 	job := &Job{
 		ID:         uuid.New().String(),
@@ -366,6 +345,10 @@ func handleUpload(w http.ResponseWriter, r *http.Request) {
 		OutputName: outputName,
 		Status:     "queued",
 		CreatedAt:  time.Now(),
+		Options:    options,
+		Owner:      owner,
+		WebhookURL:    webhookURL,
+		WebhookSecret: r.FormValue("webhook_secret"),
 	}
 	
 	// Save file
@@ -382,6 +365,66 @@ func handleUpload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
+	// Rejecting the job after this point removes everything saved for it
+	reject := func(message string, status int) {
+		removeJobInputs(job, uploadPath)
+		http.Error(w, message, status)
+	}
+	
+	// Optional subtitle sidecar
+	if subFile, subHeader, err := r.FormFile("subtitle"); err == nil {
+		defer subFile.Close()
+		
+		if options.Subtitles != SubtitlesSoft && options.Subtitles != SubtitlesBurn {
+			reject("A subtitle file needs subtitles=soft or subtitles=burn", http.StatusBadRequest)
+			return
+		}
+		
+		subPath, err := saveSubtitleSidecar(job, subFile, subHeader)
+		if err != nil {
+			reject("Invalid subtitle file: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		job.SubtitleFile = subHeader.Filename
+		job.SubtitleInputPath = subPath
+	}
+	
+	// Optional watermark logo for this job only
+	if wmFile, _, err := r.FormFile("watermark"); err == nil {
+		defer wmFile.Close()
+		
+		if err := saveWatermarkImage(wmFile, jobWatermarkPath(job)); err != nil {
+			reject("Invalid watermark: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		job.WatermarkPath = jobWatermarkPath(job)
+		job.Options.WatermarkImage = true
+		if err := job.Options.parseWatermarkOptions(get); err != nil {
+			reject(err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if err := resolveWatermarkImage(job, owner); err != nil {
+		reject(err.Error(), http.StatusBadRequest)
+		return
+	}
+	
+	// Reject impossible size targets before queueing
+	if options.TargetSizeMB > 0 {
+		duration, _ := getVideoDuration(uploadPath)
+		if _, err := planTargetBitrate(options.TargetSizeMB, options.ClipDuration(duration)); err != nil {
+			reject(err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	
+	if batch != nil {
+		if err := addJobToBatch(batch, job); err != nil {
+			reject(err.Error(), http.StatusConflict)
+			return
+		}
+	}
+	
 	// Add to queue
 	queue.mu.Lock()
 	queue.jobs = append(queue.jobs, job)
@@ -412,6 +455,9 @@ func handleGetJobs(w http.ResponseWriter, r *http.Request) {
 	for _, job := range queue.completed {
 		allJobs = append(allJobs, job)
 	}
+	for _, job := range queue.failed {
+		allJobs = append(allJobs, job)
+	}
 	
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(allJobs)
@@ -424,28 +470,45 @@ func handleGetJob(w http.ResponseWriter, r *http.Request) {
 	queue.mu.RLock()
 	defer queue.mu.RUnlock()
 	
-	// Check all states
+	if job, exists := findJobLocked(jobID); exists {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(job)
+		return
+	}
+	
+	http.Error(w, "Job not found", http.StatusNotFound)
+}
+
+// findJobLocked looks a job up in every state. Caller must hold queue.mu.
+func findJobLocked(jobID string) (*Job, bool) {
 	for _, job := range queue.jobs {
 		if job.ID == jobID {
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(job)
-			return
+			return job, true
 		}
 	}
 	
 	if job, exists := queue.processing[jobID]; exists {
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(job)
-		return
+		return job, true
 	}
 	
 	if job, exists := queue.completed[jobID]; exists {
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(job)
-		return
+		return job, true
 	}
 	
-	http.Error(w, "Job not found", http.StatusNotFound)
+	if job, exists := queue.failed[jobID]; exists {
+		return job, true
+	}
+	
+	// Downloading batch jobs are only held by their batch
+	for _, batch := range queue.batches {
+		for _, job := range batch.jobs {
+			if job.ID == jobID {
+				return job, true
+			}
+		}
+	}
+	
+	return nil, false
 }
 
 func handleDownload(w http.ResponseWriter, r *http.Request) {
@@ -461,6 +524,31 @@ func handleDownload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
+	// Bot results are only served through the signed links the bot sends
+	if isTelegramOwner(job.Owner) {
+		http.Error(w, "Download this result with the link the bot sent", http.StatusForbidden)
+		return
+	}
+	
+	serveJobDownload(w, r, job)
+}
+
+// serveJobDownload sends a completed job's output as an attachment
+func serveJobDownload(w http.ResponseWriter, r *http.Request, job *Job) {
+	// Packaged jobs have no single file; send their playlists as a zip
+	if job.Options.IsStreaming() {
+		queue.mu.RLock()
+		snapshot := *job
+		queue.mu.RUnlock()
+		
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", job.OutputName))
+		if err := writeJobsArchive(w, []Job{snapshot}, nil); err != nil {
+			log.Printf("Job %s: zip failed: %v", job.ID, err)
+		}
+		return
+	}
+	
 	outputPath := filepath.Join(OutputDir, job.ID+"_"+job.OutputName)
 	
 	// Check if file exists
@@ -470,102 +558,32 @@ func handleDownload(w http.ResponseWriter, r *http.Request) {
 	}
 	
 	// Set headers
-	w.Header().Set("Content-Type", "video/mp4")
+	w.Header().Set("Content-Type", contentTypeFor(job.OutputName))
 	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", job.OutputName))
 	
 	// Serve file
 	http.ServeFile(w, r, outputPath)
 }
 
-func handleDownloadAll(w http.ResponseWriter, r *http.Request) {
-	var request struct {
-		JobIDs []string `json:"job_ids"`
-	}
-	
-	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
-	
-	// Create temp zip file
-	tempZip := filepath.Join(TempDir, fmt.Sprintf("download_%d.zip", time.Now().Unix()))
-	zipFile, err := os.Create(tempZip)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	defer os.Remove(tempZip)
-	defer zipFile.Close()
-	
-	// Create zip writer
-	zipWriter := zip.NewWriter(zipFile)
-	
+// broadcastUpdate publishes the job to its WebSocket subscribers, updates
+// its batch, fires webhooks on status changes and wakes waiters once it finished
+func broadcastUpdate(job *Job) {
 	queue.mu.RLock()
-	for _, jobID := range request.JobIDs {
-		if job, exists := queue.completed[jobID]; exists {
-			outputPath := filepath.Join(OutputDir, job.ID+"_"+job.OutputName)
-			
-			// Add file to zip
-			if fileData, err := os.ReadFile(outputPath); err == nil {
-				if w, err := zipWriter.Create(job.OutputName); err == nil {
-					w.Write(fileData)
-				}
-			}
-		}
-	}
+	payload, err := json.Marshal(job)
+	event := &Event{Type: "job_update", JobID: job.ID, BatchID: job.BatchID, Owner: job.Owner, Payload: payload, Final: isFinalStatus(job.Status)}
 	queue.mu.RUnlock()
 	
-	zipWriter.Close()
-	
-	// Serve zip file
-	w.Header().Set("Content-Type", "application/zip")
-	w.Header().Set("Content-Disposition", "attachment; filename=\"converted_videos.zip\"")
-	http.ServeFile(w, r, tempZip)
-}
-
-func handleWebSocket(w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Printf("WebSocket upgrade failed: %v", err)
-		return
-	}
-	defer conn.Close()
-	
-	queue.mu.Lock()
-	queue.clients[conn] = true
-	queue.mu.Unlock()
-	
-	defer func() {
-		queue.mu.Lock()
-		delete(queue.clients, conn)
-		queue.mu.Unlock()
-	}()
-	
-	// Keep connection alive
-	for {
-		_, _, err := conn.ReadMessage()
-		if err != nil {
-			break
-		}
-	}
-}
-
-func broadcastUpdate(job *Job) {
-	message := map[string]interface{}{
-		"type": "job_update",
-		"job":  job,
+		log.Printf("Broadcast encoding failed: %v", err)
+	} else {
+		hub.Publish(event)
 	}
 	
-	queue.mu.RLock()
-	defer queue.mu.RUnlock()
-	
-	for client := range queue.clients {
-		err := client.WriteJSON(message)
-		if err != nil {
-			client.Close()
-			delete(queue.clients, client)
-		}
+	if event.BatchID != "" {
+		broadcastBatchUpdate(event.BatchID)
 	}
+	notifyWebhooks(job)
+	notifyJobDone(job)
 }
 
 // Processing Functions
@@ -587,6 +605,12 @@ func queueProcessor() {
 			job := queue.jobs[0]
 			queue.jobs = queue.jobs[1:]
 			queue.processing[job.ID] = job
+			job.Status = "processing"
+			
+			// Set before the lock is released so a cancel request always
+			// finds a function to call
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+			job.cancel = cancel
 			
 			for i, j := range queue.jobs {
 				j.QueuePos = i + 1
@@ -595,7 +619,7 @@ func queueProcessor() {
 			queue.mu.Unlock()
 			
 			log.Printf("🚀 Starting job %s (CPU: %.1f%%)", job.ID, cpuUsage)
-			go processJob(job)
+			go processJob(ctx, cancel, job)
 		} else {
 			queue.mu.Unlock()
 		}
@@ -608,71 +632,129 @@ func queueProcessor() {
 	}
 }
 
-func processJob(job *Job) {
+// processJob converts a job queueProcessor moved to processing; cancel
+// stops ctx and is already stored on the job
+func processJob(ctx context.Context, cancel context.CancelFunc, job *Job) {
 	log.Printf("Processing job: %s", job.ID)
+	defer cancel()
 	
-	job.Status = "processing"
+	queue.mu.Lock()
 	job.StartedAt = time.Now()
 	job.Progress = 0
+	job.Stats = nil
+	queue.mu.Unlock()
 	broadcastUpdate(job)
 	
 	inputPath := filepath.Join(UploadDir, job.ID+"_"+job.FileName)
 	outputPath := filepath.Join(OutputDir, job.ID+"_"+job.OutputName)
 	
-	duration, err := getVideoDuration(inputPath)
+	var duration float64
+	inputInfo, err := probeMedia(ctx, inputPath)
 	if err != nil {
-		log.Printf("Warning: Could not get duration: %v", err)
-		duration = 0
+		log.Printf("Warning: Could not probe input: %v", err)
+	} else {
+		duration = inputInfo.Duration
+		queue.mu.Lock()
+		job.InputInfo = inputInfo
+		queue.mu.Unlock()
 	}
 	
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
-	defer cancel()
+	reporter := newProgressReporter(job)
 	
-	err = convertVideoWithProgress(ctx, inputPath, outputPath, duration, func(progress float64) {
-		job.Progress = int(progress)
-		broadcastUpdate(job)
-		
-		// Update Telegram if it's a Telegram job
-		if job.TelegramChatID != 0 && telegramBot != nil {
-			// Rate limit updates
-			if int(progress)%10 == 0 {
-				editMsg := tgbotapi.NewEditMessageText(job.TelegramChatID, job.TelegramMsgID,
-					fmt.Sprintf("🔄 Converting... %d%%", int(progress)))
-				telegramBot.Send(editMsg)
-			}
+	// Progress is measured against the clipped segment, not the whole input
+	clipDuration := job.Options.ClipDuration(duration)
+	err = job.Options.Validate(inputInfo)
+	if err == nil && job.Options.TargetSizeMB > 0 {
+		// Fail early if the size cannot be reached at reasonable quality
+		var bitrate *BitratePlan
+		bitrate, err = planTargetBitrate(job.Options.TargetSizeMB, clipDuration)
+		queue.mu.Lock()
+		job.Bitrate = bitrate
+		queue.mu.Unlock()
+	}
+	if err == nil {
+		// A cancel that arrived while probing must not start ffmpeg
+		queue.mu.RLock()
+		if job.cancelRequested {
+			err = context.Canceled
 		}
-	})
+		queue.mu.RUnlock()
+	}
+	if err == nil {
+		resolveAutoCrop(ctx, job, inputPath, duration)
+		prepareSubtitles(ctx, job, inputPath)
+		err = runConversionChain(ctx, job, inputPath, outputPath, clipDuration, reporter.Report)
+	}
 	
-	if err != nil {
-		log.Printf("First attempt failed for %s, trying fallback: %v", job.ID, err)
-		err = fallbackConversion(ctx, inputPath, outputPath)
+	// Output details, poster, sprite sheet and animated preview
+	if err == nil {
+		mediaPath := mediaOutputPath(job)
+		outputInfo, probeErr := probeMedia(ctx, mediaPath)
+		if probeErr != nil {
+			log.Printf("Warning: Could not probe output: %v", probeErr)
+		}
+		previews := generatePreviews(ctx, job, mediaPath, clipDuration)
+		queue.mu.Lock()
+		job.OutputInfo = outputInfo
+		job.Previews = previews
+		queue.mu.Unlock()
 	}
 	
 	queue.mu.Lock()
 	delete(queue.processing, job.ID)
+	job.cancel = nil
+	cancelled := job.cancelRequested
 	
-	if err != nil {
+	if cancelled {
+		finishCancelledJobLocked(job)
+		log.Printf("Job %s cancelled", job.ID)
+	} else if err != nil {
 		job.Status = "failed"
-		job.Error = err.Error()
+		job.CompletedAt = time.Now()
+		keepFailedJobLocked(job)
+		var ffErr *FFmpegError
+		if errors.As(err, &ffErr) {
+			job.FailureReason = ffErr.Reason
+			job.Error = fmt.Sprintf("%s (tried: %s)", failureMessage(ffErr.Reason), attemptedStrategies(job))
+		} else {
+			job.Error = err.Error()
+		}
 		log.Printf("Job %s failed: %v", job.ID, err)
 	} else {
 		job.Status = "completed"
 		job.Progress = 100
 		job.CompletedAt = time.Now()
+		job.SourceAvailable = retainSources
 		queue.completed[job.ID] = job
 		log.Printf("Job %s completed in %s", job.ID, time.Since(job.StartedAt).Round(time.Second))
 	}
+	completed := job.Status == "completed"
 	queue.mu.Unlock()
 	
 	broadcastUpdate(job)
 	
-	// Cleanup input
-	os.Remove(inputPath)
+	// Failed and cancelled jobs keep their inputs for a retry, see keepFailedJobLocked
+	if !completed {
+		if cancelled {
+			removeJobOutputs(job)
+		}
+		return
+	}
+	
+	// Cleanup inputs; a retained source is removed with the output
+	if job.SourceAvailable {
+		removeJobInputs(job, "")
+	} else {
+		removeJobInputs(job, inputPath)
+	}
 	
 	// Schedule output cleanup after 1 hour
 	go func() {
 		time.Sleep(1 * time.Hour)
 		os.Remove(outputPath)
+		os.Remove(inputPath)
+		os.RemoveAll(assetsDir(job))
+		os.RemoveAll(streamDir(job))
 		
 		queue.mu.Lock()
 		delete(queue.completed, job.ID)
@@ -680,95 +762,31 @@ func processJob(job *Job) {
 	}()
 }
 
-// FFmpeg Functions
-func getVideoDuration(filepath string) (float64, error) {
-	cmd := exec.Command("ffprobe",
-		"-v", "error",
-		"-show_entries", "format=duration",
-		"-of", "default=noprint_wrappers=1:nokey=1",
-		filepath)
-	
-	output, err := cmd.Output()
-	if err != nil {
-		return 0, err
+// removeJobInputs deletes the files uploaded for a job; pass "" as inputPath
+// to keep the source. An owner's default watermark is shared and kept.
+func removeJobInputs(job *Job, inputPath string) {
+	if inputPath != "" {
+		os.Remove(inputPath)
 	}
-	
-	duration, err := strconv.ParseFloat(strings.TrimSpace(string(output)), 64)
-	if err != nil {
-		return 0, err
+	if job.SubtitleInputPath != "" {
+		os.Remove(job.SubtitleInputPath)
+	}
+	if job.WatermarkPath == jobWatermarkPath(job) {
+		os.Remove(job.WatermarkPath)
 	}
-	
-	return duration, nil
 }
 
-func convertVideoWithProgress(ctx context.Context, input, output string, duration float64, progressCallback func(float64)) error {
-	cmd := exec.CommandContext(ctx, "nice", "-n", "10", "ffmpeg",
-		"-i", input,
-		"-threads", "2",
-		"-c:v", "libx264",
-		"-preset", "ultrafast",
-		"-crf", "28",
-		"-c:a", "copy",
-		"-movflags", "+faststart",
-		"-max_muxing_queue_size", "9999",
-		"-progress", "pipe:1",
-		"-y", output)
-	
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		return err
-	}
-	
-	if err := cmd.Start(); err != nil {
-		return err
-	}
-	
-	scanner := bufio.NewScanner(stdout)
-	var currentTime float64
+// FFmpeg Functions
+func getVideoDuration(filepath string) (float64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
 	
-	for scanner.Scan() {
-		line := scanner.Text()
-		
-		if strings.HasPrefix(line, "out_time_ms=") {
-			timeStr := strings.TrimPrefix(line, "out_time_ms=")
-			if timeMicros, err := strconv.ParseInt(timeStr, 10, 64); err == nil {
-				currentTime = float64(timeMicros) / 1000000.0
-				
-				if duration > 0 {
-					progress := (currentTime / duration) * 100
-					if progress > 100 {
-						progress = 100
-					}
-					progressCallback(progress)
-				}
-			}
-		} else if strings.HasPrefix(line, "progress=") {
-			status := strings.TrimPrefix(line, "progress=")
-			if status == "end" {
-				progressCallback(100)
-			}
-		}
+	info, err := probeMedia(ctx, filepath)
+	if err != nil {
+		return 0, err
 	}
 	
-	return cmd.Wait()
-}
-
-func fallbackConversion(ctx context.Context, input, output string) error {
-	log.Printf("Running fallback conversion for %s", input)
-	
-	cmd := exec.CommandContext(ctx, "ffmpeg",
-		"-i", input,
-		"-c:v", "libx264",
-		"-preset", "veryfast",
-		"-crf", "28",
-		"-c:a", "aac",
-		"-b:a", "128k",
-		"-ar", "44100",
-		"-movflags", "+faststart",
-		"-max_muxing_queue_size", "9999",
-		"-y", output)
-	
-	return cmd.Run()
+	return info.Duration, nil
 }
 
 // Helper Functions
@@ -796,6 +814,21 @@ func getDefaultName(filename string) string {
 	return fmt.Sprintf("%s_%s.mp4", base, timestamp)
 }
 
+func contentTypeFor(name string) string {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".mkv":
+		return "video/x-matroska"
+	case ".webm":
+		return "video/webm"
+	case ".srt":
+		return "application/x-subrip"
+	case ".vtt":
+		return "text/vtt"
+	default:
+		return "video/mp4"
+	}
+}
+
 func sanitizeFilename(name string) string {
 	name = strings.ReplaceAll(name, "/", "_")
 	name = strings.ReplaceAll(name, "\\", "_")
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// MediaInfo is the ffprobe view of a media file
+type MediaInfo struct {
+	Container  string            `json:"container"`
+	FormatName string            `json:"format_name"`
+	Duration   float64           `json:"duration"`
+	Size       int64             `json:"size"`
+	BitRate    int64             `json:"bit_rate,omitempty"`
+	Tags       map[string]string `json:"tags,omitempty"`
+	Chapters   int               `json:"chapters,omitempty"`
+	Streams    []StreamInfo      `json:"streams"`
+}
+
+// StreamInfo describes a single audio, video or subtitle stream
+type StreamInfo struct {
+	Index         int     `json:"index"`
+	Type          string  `json:"type"`
+	Codec         string  `json:"codec"`
+	CodecName     string  `json:"codec_long_name,omitempty"`
+	Profile       string  `json:"profile,omitempty"`
+	BitRate       int64   `json:"bit_rate,omitempty"`
+	Language      string  `json:"language,omitempty"`
+	Title         string  `json:"title,omitempty"`
+	Width         int     `json:"width,omitempty"`
+	Height        int     `json:"height,omitempty"`
+	FrameRate     float64 `json:"frame_rate,omitempty"`
+	PixelFormat   string  `json:"pixel_format,omitempty"`
+	Rotation      int     `json:"rotation,omitempty"`
+	Channels      int     `json:"channels,omitempty"`
+	ChannelLayout string  `json:"channel_layout,omitempty"`
+	SampleRate    int     `json:"sample_rate,omitempty"`
+}
+
+// ffprobe -print_format json output, only the fields we use
+type ffprobeOutput struct {
+	Format struct {
+		FormatName     string            `json:"format_name"`
+		FormatLongName string            `json:"format_long_name"`
+		Duration       string            `json:"duration"`
+		Size           string            `json:"size"`
+		BitRate        string            `json:"bit_rate"`
+		Tags           map[string]string `json:"tags"`
+	} `json:"format"`
+	Streams []struct {
+		Index         int               `json:"index"`
+		CodecType     string            `json:"codec_type"`
+		CodecName     string            `json:"codec_name"`
+		CodecLongName string            `json:"codec_long_name"`
+		Profile       string            `json:"profile"`
+		BitRate       string            `json:"bit_rate"`
+		Width         int               `json:"width"`
+		Height        int               `json:"height"`
+		AvgFrameRate  string            `json:"avg_frame_rate"`
+		RFrameRate    string            `json:"r_frame_rate"`
+		PixFmt        string            `json:"pix_fmt"`
+		Channels      int               `json:"channels"`
+		ChannelLayout string            `json:"channel_layout"`
+		SampleRate    string            `json:"sample_rate"`
+		Tags          map[string]string `json:"tags"`
+		SideDataList  []struct {
+			Rotation float64 `json:"rotation"`
+		} `json:"side_data_list"`
+	} `json:"streams"`
+	Chapters []json.RawMessage `json:"chapters"`
+}
+
+// probeMedia runs ffprobe on path and returns its parsed output
+func probeMedia(ctx context.Context, path string) (*MediaInfo, error) {
+	cmd := exec.CommandContext(ctx, "ffprobe",
+		"-v", "error",
+		"-print_format", "json",
+		"-show_format",
+		"-show_streams",
+		"-show_chapters",
+		path)
+
+	output, err := cmd.Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return nil, fmt.Errorf("ffprobe: %s", lastStderrLine(string(exitErr.Stderr)))
+		}
+		return nil, err
+	}
+
+	var raw ffprobeOutput
+	if err := json.Unmarshal(output, &raw); err != nil {
+		return nil, fmt.Errorf("ffprobe: %v", err)
+	}
+
+	info := &MediaInfo{
+		Container:  raw.Format.FormatLongName,
+		FormatName: raw.Format.FormatName,
+		Tags:       raw.Format.Tags,
+		Chapters:   len(raw.Chapters),
+		Streams:    make([]StreamInfo, 0, len(raw.Streams)),
+	}
+	info.Duration, _ = strconv.ParseFloat(raw.Format.Duration, 64)
+	info.Size, _ = strconv.ParseInt(raw.Format.Size, 10, 64)
+	info.BitRate, _ = strconv.ParseInt(raw.Format.BitRate, 10, 64)
+
+	for _, s := range raw.Streams {
+		stream := StreamInfo{
+			Index:         s.Index,
+			Type:          s.CodecType,
+			Codec:         s.CodecName,
+			CodecName:     s.CodecLongName,
+			Profile:       s.Profile,
+			Width:         s.Width,
+			Height:        s.Height,
+			PixelFormat:   s.PixFmt,
+			Channels:      s.Channels,
+			ChannelLayout: s.ChannelLayout,
+			Language:      s.Tags["language"],
+			Title:         s.Tags["title"],
+		}
+		stream.BitRate, _ = strconv.ParseInt(s.BitRate, 10, 64)
+		stream.SampleRate, _ = strconv.Atoi(s.SampleRate)
+
+		stream.FrameRate = parseFrameRate(s.AvgFrameRate)
+		if stream.FrameRate == 0 {
+			stream.FrameRate = parseFrameRate(s.RFrameRate)
+		}
+
+		// Older muxers use a rotate tag, newer ones a display matrix
+		if rotate, err := strconv.Atoi(s.Tags["rotate"]); err == nil {
+			stream.Rotation = rotate
+		}
+		for _, side := range s.SideDataList {
+			if side.Rotation != 0 {
+				stream.Rotation = int(side.Rotation)
+			}
+		}
+
+		info.Streams = append(info.Streams, stream)
+	}
+
+	return info, nil
+}
+
+// VideoStream returns the first video stream, or nil
+func (m *MediaInfo) VideoStream() *StreamInfo {
+	for i := range m.Streams {
+		// Cover art is reported as a video stream too
+		if m.Streams[i].Type == "video" && m.Streams[i].Codec != "mjpeg" && m.Streams[i].Codec != "png" {
+			return &m.Streams[i]
+		}
+	}
+	return nil
+}
+
+// HasStream reports whether the file contains a stream of the given type
+func (m *MediaInfo) HasStream(codecType string) bool {
+	for _, s := range m.Streams {
+		if s.Type == codecType {
+			return true
+		}
+	}
+	return false
+}
+
+func parseFrameRate(rate string) float64 {
+	num, den, ok := strings.Cut(rate, "/")
+	if !ok {
+		f, _ := strconv.ParseFloat(rate, 64)
+		return f
+	}
+	n, errN := strconv.ParseFloat(num, 64)
+	d, errD := strconv.ParseFloat(den, 64)
+	if errN != nil || errD != nil || d == 0 {
+		return 0
+	}
+	return n / d
+}
+
+// Info handlers
+
+func handleJobInfo(w http.ResponseWriter, r *http.Request) {
+	jobID := mux.Vars(r)["id"]
+
+	queue.mu.RLock()
+	job, exists := findJobLocked(jobID)
+	var response struct {
+		ID     string     `json:"id"`
+		Input  *MediaInfo `json:"input"`
+		Output *MediaInfo `json:"output"`
+	}
+	if exists {
+		response.ID = job.ID
+		response.Input = job.InputInfo
+		response.Output = job.OutputInfo
+	}
+	queue.mu.RUnlock()
+
+	if !exists {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// handleProbe analyses an uploaded file without converting it
+func handleProbe(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, MaxFileSize+1024*1024)
+	r.ParseMultipartForm(32 << 20)
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	tempPath := filepath.Join(TempDir, "probe_"+uuid.New().String()+filepath.Ext(sanitizeFilename(header.Filename)))
+	dst, err := os.Create(tempPath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(tempPath)
+
+	_, err = io.Copy(dst, file)
+	dst.Close()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	info, err := probeMedia(ctx, tempPath)
+	if err != nil {
+		http.Error(w, "Could not analyse file: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
@@ -0,0 +1,42 @@
+package main
+
+import "testing"
+
+func TestParseFrameRate(t *testing.T) {
+	tests := []struct {
+		in   string
+		want float64
+	}{
+		{"30/1", 30},
+		{"30000/1001", 30000.0 / 1001},
+		{"25", 25},
+		{"0/0", 0},
+		{"1/x", 0},
+		{"", 0},
+	}
+
+	for _, tt := range tests {
+		if got := parseFrameRate(tt.in); got != tt.want {
+			t.Errorf("parseFrameRate(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestVideoStreamSkipsCoverArt(t *testing.T) {
+	info := &MediaInfo{Streams: []StreamInfo{
+		{Index: 0, Type: "audio", Codec: "opus"},
+		{Index: 1, Type: "video", Codec: "mjpeg"},
+		{Index: 2, Type: "video", Codec: "vp9", Height: 720},
+	}}
+	if v := info.VideoStream(); v == nil || v.Index != 2 {
+		t.Errorf("VideoStream() = %+v, want stream 2", v)
+	}
+	if !info.HasStream("audio") || info.HasStream("subtitle") {
+		t.Error("HasStream() disagrees with the streams")
+	}
+
+	coverOnly := &MediaInfo{Streams: []StreamInfo{{Type: "video", Codec: "png"}}}
+	if v := coverOnly.VideoStream(); v != nil {
+		t.Errorf("VideoStream() of cover art only = %+v, want nil", v)
+	}
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Output containers
+const (
+	FormatMP4 = "mp4"
+	FormatMKV = "mkv"
+)
+
+// Subtitle handling modes
+const (
+	SubtitlesSoft    = "soft"    // mov_text for MP4, passthrough for MKV
+	SubtitlesBurn    = "burn"    // render the first track into the picture
+	SubtitlesExtract = "extract" // write .srt/.vtt sidecar files
+	SubtitlesNone    = "none"
+)
+
+// MaxMetadataLength caps user supplied title/author/comment values
+const MaxMetadataLength = 256
+
+// textSubtitleCodecs can be converted to mov_text, srt and vtt;
+// bitmap formats such as PGS cannot
+var textSubtitleCodecs = map[string]bool{
+	"webvtt":   true,
+	"subrip":   true,
+	"srt":      true,
+	"ass":      true,
+	"ssa":      true,
+	"mov_text": true,
+	"text":     true,
+}
+
+func (o *ConversionOptions) parseMetadataOptions(get func(string) string) error {
+	switch v := strings.ToLower(get("format")); v {
+	case "", FormatMP4:
+		o.Format = FormatMP4
+	case FormatMKV, "matroska":
+		o.Format = FormatMKV
+	default:
+		return fmt.Errorf("format must be mp4 or mkv")
+	}
+
+	switch v := strings.ToLower(get("metadata")); v {
+	case "", "keep", "copy":
+	case "strip", "none":
+		o.StripMetadata = true
+	default:
+		return fmt.Errorf("metadata must be keep or strip")
+	}
+
+	switch v := strings.ToLower(get("chapters")); v {
+	case "", "true", "keep", "1":
+	case "false", "drop", "0":
+		o.DropChapters = true
+	default:
+		return fmt.Errorf("chapters must be true or false")
+	}
+
+	for key, dest := range map[string]*string{"title": &o.Title, "author": &o.Author, "comment": &o.Comment} {
+		value := strings.TrimSpace(strings.NewReplacer("\r", " ", "\n", " ").Replace(get(key)))
+		if len(value) > MaxMetadataLength {
+			return fmt.Errorf("%s is longer than %d characters", key, MaxMetadataLength)
+		}
+		*dest = value
+	}
+
+	switch v := strings.ToLower(get("subtitles")); v {
+	case "", SubtitlesSoft:
+		o.Subtitles = SubtitlesSoft
+	case SubtitlesBurn, SubtitlesExtract, SubtitlesNone:
+		o.Subtitles = v
+	default:
+		return fmt.Errorf("subtitles must be soft, burn, extract or none")
+	}
+
+	return nil
+}
+
+// OutputExtension returns the file extension for the chosen container
+func (o ConversionOptions) OutputExtension() string {
+	if o.IsStreaming() {
+		// Packaged jobs download as a zip of playlists and segments
+		return ".zip"
+	}
+	if o.Format == FormatMKV {
+		return ".mkv"
+	}
+	return ".mp4"
+}
+
+// withOutputExtension swaps the extension of name for the job's container
+func withOutputExtension(name string, opts ConversionOptions) string {
+	ext := opts.OutputExtension()
+	if strings.HasSuffix(strings.ToLower(name), ext) {
+		return name
+	}
+	return strings.TrimSuffix(name, ".mp4") + ext
+}
+
+// textSubtitleStreams returns the input subtitle streams we can convert
+func textSubtitleStreams(info *MediaInfo) []StreamInfo {
+	streams := make([]StreamInfo, 0)
+	if info == nil {
+		return streams
+	}
+	for _, s := range info.Streams {
+		if s.Type == "subtitle" && textSubtitleCodecs[s.Codec] {
+			streams = append(streams, s)
+		}
+	}
+	return streams
+}
+
+// streamArgs maps the input streams explicitly so subtitle tracks are no
+// longer dropped, and picks a subtitle codec the container accepts.
+// videoMap is the input stream or filter graph label carrying the video.
+func streamArgs(job *Job, videoMap string) []string {
+	args := []string{"-map", videoMap}
+	if job.Options.Mute {
+		args = append(args, "-an")
+	} else {
+		args = append(args, "-map", "0:a?")
+	}
+
+	if job.Options.Subtitles != SubtitlesSoft {
+		return append(args, "-sn")
+	}
+
+	subs := textSubtitleStreams(job.InputInfo)
+	if len(subs) == 0 && job.SubtitleInputPath == "" {
+		return append(args, "-sn")
+	}
+	for _, s := range subs {
+		args = append(args, "-map", fmt.Sprintf("0:%d", s.Index))
+	}
+	if job.SubtitleInputPath != "" {
+		// Uploaded sidecar, added as input 1 by sidecarInputArgs
+		args = append(args, "-map", "1:0")
+	}
+	if job.Options.Format == FormatMKV {
+		args = append(args, "-c:s", "copy")
+	} else {
+		args = append(args, "-c:s", "mov_text")
+	}
+	return args
+}
+
+// metadataArgs copies or strips global tags and chapters and applies the
+// per-job title/author/comment
+func metadataArgs(opts ConversionOptions) []string {
+	args := make([]string, 0)
+
+	if opts.StripMetadata {
+		args = append(args, "-map_metadata", "-1")
+	} else {
+		args = append(args, "-map_metadata", "0")
+	}
+	if opts.DropChapters {
+		args = append(args, "-map_chapters", "-1")
+	} else {
+		args = append(args, "-map_chapters", "0")
+	}
+
+	if opts.Title != "" {
+		args = append(args, "-metadata", "title="+opts.Title)
+	}
+	if opts.Author != "" {
+		args = append(args, "-metadata", "artist="+opts.Author, "-metadata", "author="+opts.Author)
+	}
+	if opts.Comment != "" {
+		args = append(args, "-metadata", "comment="+opts.Comment)
+	}
+	return args
+}
+
+// containerArgs returns muxer options for the output format
+func containerArgs(opts ConversionOptions) []string {
+	if opts.Format == FormatMKV {
+		return nil
+	}
+	// use_metadata_tags keeps arbitrary WebM tags the MP4 muxer would drop
+	return []string{"-movflags", "+faststart+use_metadata_tags"}
+}
+
+// prepareSubtitles extracts subtitle tracks before conversion: the first
+// track for burn-in, or every text track as .srt/.vtt sidecars
+func prepareSubtitles(ctx context.Context, job *Job, input string) {
+	if job.Options.Subtitles != SubtitlesBurn && job.Options.Subtitles != SubtitlesExtract {
+		return
+	}
+
+	subs := textSubtitleStreams(job.InputInfo)
+	if len(subs) == 0 && job.SubtitleInputPath == "" {
+		log.Printf("Job %s: no text subtitle tracks to %s", job.ID, job.Options.Subtitles)
+		return
+	}
+
+	if err := ensureAssetsDir(job); err != nil {
+		log.Printf("Job %s: %v", job.ID, err)
+		return
+	}
+
+	trim := append(job.Options.inputArgs(), "-i", input)
+	trim = append(trim, job.Options.trimArgs()...)
+
+	if job.Options.Subtitles == SubtitlesBurn {
+		// An uploaded sidecar wins over embedded tracks
+		source := "0:0"
+		if job.SubtitleInputPath != "" {
+			trim = append(job.Options.seekArgs(), "-i", job.SubtitleInputPath)
+			trim = append(trim, job.Options.trimArgs()...)
+		} else {
+			source = fmt.Sprintf("0:%d", subs[0].Index)
+		}
+
+		path := assetPath(job, "burn.ass")
+		args := append(append([]string{}, trim...), "-map", source, "-y", path)
+		if err := runFFmpegQuiet(ctx, args...); err != nil {
+			log.Printf("Job %s: subtitle extraction failed: %v", job.ID, err)
+			return
+		}
+		queue.mu.Lock()
+		job.SubtitleBurnPath = path
+		queue.mu.Unlock()
+		return
+	}
+
+	base := sanitizeFilename(strings.TrimSuffix(job.OutputName, job.Options.OutputExtension()))
+	sidecars := make([]string, 0)
+	for i, s := range subs {
+		suffix := ""
+		if s.Language != "" && s.Language != "und" {
+			suffix = "." + sanitizeFilename(s.Language)
+		}
+		if len(subs) > 1 {
+			suffix = fmt.Sprintf(".%d%s", i+1, suffix)
+		}
+
+		for _, ext := range []string{".srt", ".vtt"} {
+			name := base + suffix + ext
+			args := append(append([]string{}, trim...), "-map", fmt.Sprintf("0:%d", s.Index), "-y", assetPath(job, name))
+			if err := runFFmpegQuiet(ctx, args...); err != nil {
+				log.Printf("Job %s: extracting %s failed: %v", job.ID, name, err)
+				continue
+			}
+			sidecars = append(sidecars, name)
+		}
+	}
+
+	queue.mu.Lock()
+	job.Sidecars = sidecars
+	queue.mu.Unlock()
+}
+
+// subtitleBurnFilter renders an extracted subtitle file into the picture.
+// The file was extracted with the same seek and duration as the video, so
+// its timestamps already line up with the trimmed frames.
+func subtitleBurnFilter(path string, opts ConversionOptions) string {
+	filter := "subtitles=filename=" + escapeFilterValue(path)
+	if style := opts.subtitleForceStyle(); style != "" {
+		filter += ":force_style='" + style + "'"
+	}
+	return filter
+}
+
+// escapeFilterValue escapes a value for use inside an ffmpeg filter graph
+func escapeFilterValue(value string) string {
+	replacer := strings.NewReplacer(`\`, `\\\\`, `'`, `\\\'`, `:`, `\\:`, `,`, `\,`, `;`, `\;`, `[`, `\[`, `]`, `\]`)
+	return replacer.Replace(value)
+}
+
+// handleSidecar serves an extracted subtitle file
+func handleSidecar(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	queue.mu.RLock()
+	job, exists := queue.completed[vars["id"]]
+	found := false
+	if exists {
+		for _, name := range job.Sidecars {
+			if name == vars["name"] {
+				found = true
+				break
+			}
+		}
+	}
+	queue.mu.RUnlock()
+
+	if !found {
+		http.Error(w, "Subtitle file not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentTypeFor(vars["name"]))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", vars["name"]))
+	http.ServeFile(w, r, assetPath(job, vars["name"]))
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWithOutputExtension(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		want   string
+	}{
+		{"clip.mp4", FormatMP4, "clip.mp4"},
+		{"clip.MP4", FormatMP4, "clip.MP4"},
+		{"clip.mp4", FormatMKV, "clip.mkv"},
+		{"clip.mkv", FormatMKV, "clip.mkv"},
+		{"clip", FormatMKV, "clip.mkv"},
+	}
+
+	for _, tt := range tests {
+		if got := withOutputExtension(tt.name, ConversionOptions{Format: tt.format}); got != tt.want {
+			t.Errorf("withOutputExtension(%q, %s) = %q, want %q", tt.name, tt.format, got, tt.want)
+		}
+	}
+}
+
+func TestStreamArgs(t *testing.T) {
+	info := &MediaInfo{Streams: []StreamInfo{
+		{Index: 0, Type: "video", Codec: "vp9"},
+		{Index: 1, Type: "audio", Codec: "opus"},
+		{Index: 2, Type: "subtitle", Codec: "webvtt"},
+		{Index: 3, Type: "subtitle", Codec: "hdmv_pgs_subtitle"},
+	}}
+	noSubs := &MediaInfo{Streams: info.Streams[:2]}
+
+	tests := []struct {
+		name string
+		job  *Job
+		want []string
+	}{
+		{
+			name: "soft subtitles in mp4",
+			job:  &Job{InputInfo: info, Options: ConversionOptions{Format: FormatMP4, Subtitles: SubtitlesSoft}},
+			want: []string{"-map", "0:v:0", "-map", "0:a?", "-map", "0:2", "-c:s", "mov_text"},
+		},
+		{
+			name: "soft subtitles in mkv with a sidecar",
+			job:  &Job{InputInfo: info, SubtitleInputPath: "subs.srt", Options: ConversionOptions{Format: FormatMKV, Subtitles: SubtitlesSoft}},
+			want: []string{"-map", "0:v:0", "-map", "0:a?", "-map", "0:2", "-map", "1:0", "-c:s", "copy"},
+		},
+		{
+			name: "no text tracks",
+			job:  &Job{InputInfo: noSubs, Options: ConversionOptions{Subtitles: SubtitlesSoft}},
+			want: []string{"-map", "0:v:0", "-map", "0:a?", "-sn"},
+		},
+		{
+			name: "muted with subtitles off",
+			job:  &Job{InputInfo: info, Options: ConversionOptions{Mute: true, Subtitles: SubtitlesNone}},
+			want: []string{"-map", "0:v:0", "-an", "-sn"},
+		},
+	}
+
+	for _, tt := range tests {
+		if got := streamArgs(tt.job, "0:v:0"); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: streamArgs() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMetadataArgs(t *testing.T) {
+	got := metadataArgs(ConversionOptions{})
+	if want := []string{"-map_metadata", "0", "-map_chapters", "0"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("defaults = %q, want %q", got, want)
+	}
+
+	got = metadataArgs(ConversionOptions{StripMetadata: true, DropChapters: true, Title: "T", Author: "A", Comment: "C"})
+	want := []string{
+		"-map_metadata", "-1", "-map_chapters", "-1",
+		"-metadata", "title=T", "-metadata", "artist=A", "-metadata", "author=A", "-metadata", "comment=C",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("stripped = %q, want %q", got, want)
+	}
+
+	if args := containerArgs(ConversionOptions{Format: FormatMKV}); args != nil {
+		t.Errorf("containerArgs(mkv) = %q, want nil", args)
+	}
+}
+
+func TestEscapeFilterValue(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"/data/burn.ass", "/data/burn.ass"},
+		{`C:\subs`, `C\\:\\\\subs`},
+		{"it's", `it\\\'s`},
+		{"a,b;c[d]", `a\,b\;c\[d\]`},
+	}
+
+	for _, tt := range tests {
+		if got := escapeFilterValue(tt.in); got != tt.want {
+			t.Errorf("escapeFilterValue(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseMetadataOptions(t *testing.T) {
+	tests := []struct {
+		params  map[string]string
+		wantErr bool
+	}{
+		{map[string]string{}, false},
+		{map[string]string{"format": "matroska", "metadata": "strip", "chapters": "drop"}, false},
+		{map[string]string{"format": "avi"}, true},
+		{map[string]string{"metadata": "maybe"}, true},
+		{map[string]string{"subtitles": "sideways"}, true},
+		{map[string]string{"title": string(make([]byte, MaxMetadataLength+1))}, true},
+	}
+
+	for _, tt := range tests {
+		var opts ConversionOptions
+		err := opts.parseMetadataOptions(func(key string) string { return tt.params[key] })
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseMetadataOptions(%v) error = %v, wantErr %v", tt.params, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// errPrivateAddress is returned for user supplied URLs that lead to this
+// host or its network
+var errPrivateAddress = errors.New("private, loopback and link-local addresses are not allowed")
+
+// blockedNetworks are special-purpose ranges not covered by the net.IP
+// predicates used in isPublicIP
+var blockedNetworks = mustParseCIDRs(
+	"0.0.0.0/8",     // "this" network
+	"100.64.0.0/10", // carrier-grade NAT
+	"192.0.0.0/24",  // IETF protocol assignments
+	"198.18.0.0/15", // benchmarking
+	"240.0.0.0/4",   // reserved, including broadcast
+	"64:ff9b::/96",  // NAT64, can reach IPv4 private ranges
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// isPublicIP reports whether ip is a globally routable unicast address
+func isPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, network := range blockedNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkPublicDial runs for every connection after DNS resolution, so
+// redirects and rebinding DNS cannot reach internal hosts either
+func checkPublicDial(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+		return fmt.Errorf("%w (%s)", errPrivateAddress, host)
+	}
+	return nil
+}
+
+// newPublicHTTPClient returns a client for user supplied URLs (URL inputs,
+// Telegram links and webhooks) that only connects to public addresses
+func newPublicHTTPClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   checkPublicDial,
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// A proxy would make the dial check see only the proxy's address
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("redirect to unsupported scheme %q", req.URL.Scheme)
+			}
+			// Literal addresses fail fast; host names are checked once resolved
+			if ip := net.ParseIP(req.URL.Hostname()); ip != nil && !isPublicIP(ip) {
+				return fmt.Errorf("redirect refused: %w", errPrivateAddress)
+			}
+			return nil
+		},
+	}
+}
+
+// urlInputClient fetches batch URL inputs and Telegram links; the request
+// context carries the URLDownloadTimeout
+var urlInputClient = newPublicHTTPClient(0)
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestIsPublicIP(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"8.8.8.8", true},
+		{"2606:4700:4700::1111", true},
+		{"127.0.0.1", false},
+		{"127.8.9.10", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"255.255.255.255", false},
+		{"224.0.0.1", false},
+		{"::1", false},
+		{"::", false},
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:10.0.0.1", false},
+		{"64:ff9b::a00:1", false},
+	}
+
+	for _, tt := range tests {
+		if got := isPublicIP(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("isPublicIP(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestPublicClientRefusesLoopback(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("secret"))
+	}))
+	defer server.Close()
+
+	_, err := downloadURLToFile(context.Background(), server.URL, filepath.Join(t.TempDir(), "out"), 1024)
+	if !errors.Is(err, errPrivateAddress) {
+		t.Errorf("downloadURLToFile(%s) error = %v, want errPrivateAddress", server.URL, err)
+	}
+}
+
+func TestPublicClientRefusesPrivateRedirects(t *testing.T) {
+	client := newPublicHTTPClient(0)
+	for _, target := range []string{"http://10.0.0.1/", "http://[::1]:8080/", "file:///etc/passwd"} {
+		req, _ := http.NewRequest(http.MethodGet, target, nil)
+		if err := client.CheckRedirect(req, []*http.Request{{}}); err == nil {
+			t.Errorf("redirect to %s was allowed", target)
+		}
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "https://example.com/video.webm", nil)
+	if err := client.CheckRedirect(req, []*http.Request{{}}); err != nil {
+		t.Errorf("redirect to a public host was refused: %v", err)
+	}
+}
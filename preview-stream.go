@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// retainSources keeps uploaded inputs until the output is cleaned up, so the
+// web UI can compare original and converted side by side. It is off unless
+// RETAIN_SOURCES=true, since kept inputs roughly double disk use.
+var retainSources = false
+
+func loadRetainSources() bool {
+	retain, err := strconv.ParseBool(os.Getenv("RETAIN_SOURCES"))
+	return err == nil && retain
+}
+
+// sourcePath is where the uploaded input of a job lives
+func sourcePath(job *Job) string {
+	return filepath.Join(UploadDir, job.ID+"_"+job.FileName)
+}
+
+// handleStream serves the converted output inline for in-browser playback
+func handleStream(w http.ResponseWriter, r *http.Request) {
+	jobID := mux.Vars(r)["id"]
+
+	queue.mu.RLock()
+	job, exists := queue.completed[jobID]
+	queue.mu.RUnlock()
+
+	if !exists {
+		http.Error(w, "Job not found or not completed", http.StatusNotFound)
+		return
+	}
+
+	// Packaged jobs are played through their playlists
+	if job.Streaming != nil {
+		http.Redirect(w, r, job.Streaming.Master, http.StatusFound)
+		return
+	}
+
+	serveInline(w, r, filepath.Join(OutputDir, job.ID+"_"+job.OutputName), job.OutputName, contentTypeFor(job.OutputName))
+}
+
+// handleSource serves the retained original upload inline
+func handleSource(w http.ResponseWriter, r *http.Request) {
+	jobID := mux.Vars(r)["id"]
+
+	queue.mu.RLock()
+	job, exists := findJobLocked(jobID)
+	available := exists && job.SourceAvailable
+	queue.mu.RUnlock()
+
+	if !available {
+		http.Error(w, "Source not available", http.StatusNotFound)
+		return
+	}
+
+	serveInline(w, r, sourcePath(job), job.FileName, contentTypeFor(job.FileName))
+}
+
+// serveInline serves a file for playback. http.ServeContent handles Range,
+// If-Range, If-None-Match and If-Modified-Since against the headers set here.
+func serveInline(w http.ResponseWriter, r *http.Request, path, name, contentType string) {
+	file, err := os.Open(path)
+	if err != nil {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Outputs are written once, so size and mtime identify the content
+	w.Header().Set("ETag", fmt.Sprintf(`"%x-%x"`, info.Size(), info.ModTime().UnixNano()))
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=\"%s\"", name))
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+
+	http.ServeContent(w, r, name, info.ModTime(), file)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestServeInline(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.mp4")
+	if err := os.WriteFile(path, []byte("0123456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	serve := func(header, value string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/jobs/x/stream", nil)
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		rec := httptest.NewRecorder()
+		serveInline(rec, req, path, "out.mp4", "video/mp4")
+		return rec
+	}
+
+	full := serve("", "")
+	etag := full.Header().Get("ETag")
+	if full.Code != http.StatusOK || full.Body.String() != "0123456789" || etag == "" {
+		t.Fatalf("full response = %d %q, ETag %q", full.Code, full.Body.String(), etag)
+	}
+	if got := full.Header().Get("Content-Disposition"); got != `inline; filename="out.mp4"` {
+		t.Errorf("Content-Disposition = %q", got)
+	}
+
+	if rec := serve("Range", "bytes=2-5"); rec.Code != http.StatusPartialContent || rec.Body.String() != "2345" {
+		t.Errorf("range response = %d %q, want 206 \"2345\"", rec.Code, rec.Body.String())
+	}
+	if rec := serve("If-None-Match", etag); rec.Code != http.StatusNotModified {
+		t.Errorf("matching ETag gave %d, want 304", rec.Code)
+	}
+
+	missing := httptest.NewRecorder()
+	serveInline(missing, httptest.NewRequest(http.MethodGet, "/", nil), path+".gone", "gone.mp4", "video/mp4")
+	if missing.Code != http.StatusNotFound {
+		t.Errorf("missing file gave %d, want 404", missing.Code)
+	}
+}
+
+func TestLoadRetainSources(t *testing.T) {
+	for value, want := range map[string]bool{"": false, "false": false, "yes": false, "true": true, "1": true} {
+		t.Setenv("RETAIN_SOURCES", value)
+		if got := loadRetainSources(); got != want {
+			t.Errorf("RETAIN_SOURCES=%q gave %v, want %v", value, got, want)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ProgressStats is one coalesced reading of ffmpeg's -progress output
+type ProgressStats struct {
+	Percent     float64 `json:"percent"`
+	OutTime     float64 `json:"out_time"` // seconds of output written
+	FPS         float64 `json:"fps"`
+	Speed       float64 `json:"speed"` // multiple of real time
+	BitrateKbps float64 `json:"bitrate_kbps"`
+	TotalSize   int64   `json:"total_size"`
+	ETASeconds  float64 `json:"eta_seconds"`
+}
+
+// ProgressFunc receives progress while a strategy runs
+type ProgressFunc func(ProgressStats)
+
+// parseProgressLine applies one key=value line of ffmpeg's -progress output.
+// Values reported as N/A leave the previous reading in place.
+func (s *ProgressStats) parseProgressLine(key, value string) {
+	switch key {
+	case "fps":
+		if v, err := strconv.ParseFloat(value, 64); err == nil {
+			s.FPS = v
+		}
+	case "bitrate":
+		if v, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(value), "kbits/s"), 64); err == nil {
+			s.BitrateKbps = v
+		}
+	case "total_size":
+		if v, err := strconv.ParseInt(value, 10, 64); err == nil {
+			s.TotalSize = v
+		}
+	case "out_time_us", "out_time_ms":
+		// Both are in microseconds
+		if v, err := strconv.ParseInt(value, 10, 64); err == nil && v >= 0 {
+			s.OutTime = float64(v) / 1000000.0
+		}
+	case "speed":
+		if v, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(value), "x"), 64); err == nil {
+			s.Speed = v
+		}
+	}
+}
+
+// finish computes Percent and ETA against duration at the end of a block
+func (s *ProgressStats) finish(duration float64, ended bool) {
+	if ended {
+		s.Percent = 100
+		s.ETASeconds = 0
+		return
+	}
+	if duration <= 0 {
+		return
+	}
+
+	s.Percent = s.OutTime / duration * 100
+	if s.Percent > 100 {
+		s.Percent = 100
+	}
+	if s.Speed > 0 {
+		remaining := duration - s.OutTime
+		if remaining < 0 {
+			remaining = 0
+		}
+		s.ETASeconds = remaining / s.Speed
+	}
+}
+
+// Progress sinks and how often each is updated
+var (
+	liveProgressRate    = progressRate{interval: 1 * time.Second, delta: 1}
+	webhookProgressRate = progressRate{interval: 10 * time.Second, delta: 10}
+)
+
+// progressRate limits a sink to one update per interval with at least
+// delta percent of change; a stale reading is refreshed after 5 intervals
+type progressRate struct {
+	interval time.Duration
+	delta    float64
+}
+
+type progressSink struct {
+	rate        progressRate
+	send        func(job *Job, stats ProgressStats)
+	last        time.Time
+	lastPercent float64
+}
+
+func (s *progressSink) due(stats ProgressStats, now time.Time) bool {
+	switch {
+	case s.last.IsZero(), stats.Percent >= 100, stats.Percent < s.lastPercent:
+		return true
+	}
+	elapsed := now.Sub(s.last)
+	if elapsed < s.rate.interval {
+		return false
+	}
+	return stats.Percent-s.lastPercent >= s.rate.delta || elapsed >= 5*s.rate.interval
+}
+
+// ProgressReporter stores the job's progress and coalesces it for the
+// event bus (WebSocket/SSE subscribers and the Telegram notifier) and webhooks
+type ProgressReporter struct {
+	job   *Job
+	sinks []*progressSink
+}
+
+func newProgressReporter(job *Job) *ProgressReporter {
+	reporter := &ProgressReporter{job: job}
+	reporter.add(liveProgressRate, func(job *Job, _ ProgressStats) { broadcastUpdate(job) })
+	reporter.add(webhookProgressRate, func(job *Job, stats ProgressStats) {
+		// The processing and completed events cover both ends
+		if stats.Percent > 0 && stats.Percent < 100 {
+			notifyWebhookProgress(job)
+		}
+	})
+	return reporter
+}
+
+func (p *ProgressReporter) add(rate progressRate, send func(*Job, ProgressStats)) {
+	p.sinks = append(p.sinks, &progressSink{rate: rate, send: send})
+}
+
+// Report records stats on the job and forwards them to the sinks that are due
+func (p *ProgressReporter) Report(stats ProgressStats) {
+	queue.mu.Lock()
+	p.job.Progress = int(stats.Percent)
+	p.job.Stats = &stats
+	queue.mu.Unlock()
+
+	now := time.Now()
+	for _, sink := range p.sinks {
+		if sink.due(stats, now) {
+			sink.last = now
+			sink.lastPercent = stats.Percent
+			sink.send(p.job, stats)
+		}
+	}
+}
+
+// formatProgress summarises stats for humans, e.g. "2.1x · 30 fps · ETA 00:00:42"
+func formatProgress(stats ProgressStats) string {
+	parts := make([]string, 0, 3)
+	if stats.Speed > 0 {
+		parts = append(parts, fmt.Sprintf("%.1fx", stats.Speed))
+	}
+	if stats.FPS > 0 {
+		parts = append(parts, fmt.Sprintf("%.0f fps", stats.FPS))
+	}
+	if stats.ETASeconds > 0 {
+		parts = append(parts, "ETA "+formatTimestamp(stats.ETASeconds))
+	}
+	return strings.Join(parts, " · ")
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProgressSinkDue(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	rate := progressRate{interval: 10 * time.Second, delta: 10}
+
+	tests := []struct {
+		name    string
+		last    time.Time
+		percent float64 // last sent
+		now     time.Duration
+		stats   float64 // current
+		want    bool
+	}{
+		{"first reading", time.Time{}, 0, 0, 1, true},
+		{"too soon", start, 10, 5 * time.Second, 30, false},
+		{"too little change", start, 10, 15 * time.Second, 15, false},
+		{"enough change", start, 10, 15 * time.Second, 20, true},
+		{"stale refresh", start, 10, 50 * time.Second, 11, true},
+		{"almost stale", start, 10, 49 * time.Second, 11, false},
+		{"finished", start, 90, time.Second, 100, true},
+		{"went backwards", start, 80, time.Second, 0, true},
+	}
+
+	for _, tt := range tests {
+		sink := &progressSink{rate: rate, last: tt.last, lastPercent: tt.percent}
+		if got := sink.due(ProgressStats{Percent: tt.stats}, start.Add(tt.now)); got != tt.want {
+			t.Errorf("%s: due() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestProgressStatsParsing(t *testing.T) {
+	var stats ProgressStats
+	for _, line := range [][2]string{
+		{"fps", "29.97"},
+		{"bitrate", " 1234.5kbits/s"},
+		{"total_size", "1048576"},
+		{"out_time_us", "30000000"},
+		{"speed", "2x"},
+		{"speed", "N/A"},
+		{"fps", "N/A"},
+	} {
+		stats.parseProgressLine(line[0], line[1])
+	}
+	stats.finish(60, false)
+
+	want := ProgressStats{Percent: 50, OutTime: 30, FPS: 29.97, Speed: 2, BitrateKbps: 1234.5, TotalSize: 1048576, ETASeconds: 15}
+	if stats != want {
+		t.Errorf("stats = %+v, want %+v", stats, want)
+	}
+
+	stats.finish(60, true)
+	if stats.Percent != 100 || stats.ETASeconds != 0 {
+		t.Errorf("ended stats = %+v, want 100%% with no ETA", stats)
+	}
+}
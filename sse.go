@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// SSE settings
+const (
+	SSEHeartbeat  = 15 * time.Second
+	SSERetryDelay = 3 * time.Second
+)
+
+// sseResumable are the message types whose seq is a valid Last-Event-ID
+var sseResumable = map[string]bool{
+	"job_update":   true,
+	"batch_update": true,
+	"snapshot":     true,
+	"subscribed":   true,
+}
+
+// handleEvents streams the updates of the ?jobs= and ?batches= listed as
+// Server-Sent Events, plus all of the caller's with an API key
+func handleEvents(w http.ResponseWriter, r *http.Request) {
+	serveEvents(w, r, eventsSubscription(r))
+}
+
+// eventsSubscription reads the topics of /api/events. Keyless callers share
+// no owner, so they only get what they list.
+func eventsSubscription(r *http.Request) ClientMessage {
+	query := r.URL.Query()
+	owner, _ := apiKeyOwner(r)
+	return ClientMessage{
+		Type:    "subscribe",
+		Mine:    owner != "",
+		Jobs:    splitIDs(query.Get("jobs")),
+		Batches: splitIDs(query.Get("batches")),
+	}
+}
+
+// handleJobEvents streams the updates of one job
+func handleJobEvents(w http.ResponseWriter, r *http.Request) {
+	jobID := mux.Vars(r)["id"]
+
+	queue.mu.RLock()
+	_, exists := findJobLocked(jobID)
+	queue.mu.RUnlock()
+
+	if !exists {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+
+	serveEvents(w, r, ClientMessage{Type: "subscribe", Jobs: []string{jobID}})
+}
+
+// serveEvents registers an SSE client with the hub. Messages are the same
+// JSON as on /ws, with the event name set to the type and the id to the
+// sequence number, so EventSource resumes from Last-Event-ID on reconnect.
+func serveEvents(w http.ResponseWriter, r *http.Request, subscription ClientMessage) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("last_event_id")
+	}
+	subscription.Since, _ = strconv.ParseUint(lastEventID, 10, 64)
+
+	owner, _ := apiKeyOwner(r)
+	client := &Client{
+		hub:     hub,
+		send:    make(chan []byte, wsSendBuffer),
+		owner:   owner,
+		addr:    r.RemoteAddr,
+		jobs:    make(map[string]bool),
+		batches: make(map[string]bool),
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	fmt.Fprintf(w, "retry: %d\n\n", SSERetryDelay.Milliseconds())
+	flusher.Flush()
+
+	hub.register <- client
+	defer func() { hub.unregister <- client }()
+	hub.requests <- clientRequest{client: client, message: subscription}
+
+	heartbeat := time.NewTicker(SSEHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case data, ok := <-client.send:
+			if !ok {
+				return
+			}
+			if err := writeSSE(w, data); err != nil {
+				return
+			}
+			flusher.Flush()
+
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// writeSSE writes one hub message as an SSE event
+func writeSSE(w http.ResponseWriter, data []byte) error {
+	var header struct {
+		Type string `json:"type"`
+		Seq  uint64 `json:"seq"`
+	}
+	json.Unmarshal(data, &header)
+
+	if sseResumable[header.Type] && header.Seq > 0 {
+		if _, err := fmt.Fprintf(w, "id: %d\n", header.Seq); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", header.Type, data)
+	return err
+}
+
+// splitIDs parses a comma separated list of IDs
+func splitIDs(value string) []string {
+	ids := make([]string, 0)
+	for _, id := range strings.Split(value, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestSplitIDs(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"", []string{}},
+		{"a", []string{"a"}},
+		{" a, b ,,c ", []string{"a", "b", "c"}},
+		{",", []string{}},
+	}
+
+	for _, tt := range tests {
+		if got := splitIDs(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitIDs(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestWriteSSE(t *testing.T) {
+	tests := []struct {
+		data string
+		want string
+	}{
+		{`{"type":"job_update","seq":12}`, "id: 12\nevent: job_update\ndata: {\"type\":\"job_update\",\"seq\":12}\n\n"},
+		{`{"type":"snapshot","seq":3}`, "id: 3\nevent: snapshot\ndata: {\"type\":\"snapshot\",\"seq\":3}\n\n"},
+		// hello and errors are not resumable, so they carry no id
+		{`{"type":"hello","seq":12}`, "event: hello\ndata: {\"type\":\"hello\",\"seq\":12}\n\n"},
+		{`{"type":"error","error":"x"}`, "event: error\ndata: {\"type\":\"error\",\"error\":\"x\"}\n\n"},
+	}
+
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		if err := writeSSE(rec, []byte(tt.data)); err != nil {
+			t.Fatal(err)
+		}
+		if got := rec.Body.String(); got != tt.want {
+			t.Errorf("writeSSE(%s) wrote %q, want %q", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestEventsSubscription(t *testing.T) {
+	defer func(keys []string) { apiKeys = keys }(apiKeys)
+	apiKeys = []string{"secret"}
+
+	anonymous := eventsSubscription(httptest.NewRequest(http.MethodGet, "/api/events?jobs=a,b&batches=c", nil))
+	if anonymous.Mine || !reflect.DeepEqual(anonymous.Jobs, []string{"a", "b"}) || !reflect.DeepEqual(anonymous.Batches, []string{"c"}) {
+		t.Errorf("keyless subscription = %+v, want only the listed topics", anonymous)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/events", nil)
+	req.Header.Set("X-API-Key", "secret")
+	if keyed := eventsSubscription(req); !keyed.Mine {
+		t.Errorf("keyed subscription = %+v, want mine", keyed)
+	}
+}
@@ -64,7 +64,7 @@ go get github.com/go-telegram-bot-api/telegram-bot-api/v5
 
 # Build the server
 echo "Building server..."
-go build -o webm2mp4-server main-server.go cpu-monitor.go generate-favicon.go
+go build -o webm2mp4-server .
 
 if [ $? -ne 0 ]; then
     echo "❌ Build failed!"
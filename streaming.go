@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Adaptive streaming output modes. The DASH mode writes CMAF segments and
+// also an HLS master playlist over the same segments.
+const (
+	OutputModeFile = ""
+	OutputModeHLS  = "hls"
+	OutputModeDASH = "dash"
+)
+
+// Packaging settings
+const (
+	SegmentSeconds     = 6
+	MaxRenditions      = 5
+	StreamAudioBitrate = "128k"
+	DefaultRenditions  = "720,480,360"
+)
+
+// ladderBitrates is the default video bitrate (kbps) per rendition height
+var ladderBitrates = map[int]int{
+	2160: 12000,
+	1440: 8000,
+	1080: 5000,
+	720:  2800,
+	480:  1400,
+	360:  800,
+	240:  400,
+}
+
+// Rendition is one variant of the adaptive ladder
+type Rendition struct {
+	Height    int `json:"height"`
+	VideoKbps int `json:"video_kbps"`
+}
+
+// StreamingOutput lists the playlists of a packaged job
+type StreamingOutput struct {
+	Mode       string      `json:"mode"`
+	Master     string      `json:"master"`
+	Manifest   string      `json:"manifest,omitempty"`
+	Renditions []Rendition `json:"renditions"`
+}
+
+var packagingChain = []ConversionStrategy{
+	{
+		Name:        "package",
+		Description: "adaptive streaming ladder",
+		OutputArgs: []string{
+			"-c:v", "libx264",
+			"-preset", "veryfast",
+			"-profile:v", "main",
+			"-pix_fmt", "yuv420p",
+		},
+		Run: runPackaging,
+	},
+	{
+		Name:        "package-software",
+		Description: "adaptive streaming ladder, error tolerant",
+		InputArgs:   conversionStrategies["software"].InputArgs,
+		OutputArgs: []string{
+			"-c:v", "libx264",
+			"-preset", "veryfast",
+			"-profile:v", "main",
+			"-pix_fmt", "yuv420p",
+		},
+		Run: runPackaging,
+	},
+}
+
+func (o *ConversionOptions) parseStreamingOptions(get func(string) string) error {
+	switch v := strings.ToLower(get("output_mode")); v {
+	case "", "file", "mp4":
+		if get("renditions") != "" {
+			return fmt.Errorf("renditions need output_mode=hls or output_mode=dash")
+		}
+		return nil
+	case OutputModeHLS, OutputModeDASH:
+		o.OutputMode = v
+	case "cmaf":
+		o.OutputMode = OutputModeDASH
+	default:
+		return fmt.Errorf("output_mode must be file, hls or dash")
+	}
+
+	if o.TargetSizeMB > 0 {
+		return fmt.Errorf("target_size_mb cannot be combined with output_mode=%s", o.OutputMode)
+	}
+
+	value := get("renditions")
+	if value == "" {
+		value = DefaultRenditions
+	}
+	renditions, err := parseRenditions(value)
+	if err != nil {
+		return err
+	}
+	o.Renditions = renditions
+
+	// Playlists cannot carry our subtitle tracks; ship them as files instead
+	if o.Subtitles == SubtitlesSoft {
+		o.Subtitles = SubtitlesExtract
+	}
+	return nil
+}
+
+// parseRenditions accepts heights with optional bitrates, e.g. "1080,720@2500,480"
+func parseRenditions(value string) ([]Rendition, error) {
+	renditions := make([]Rendition, 0)
+	seen := make(map[int]bool)
+
+	for _, part := range strings.Split(value, ",") {
+		part = strings.ToLower(strings.TrimSpace(part))
+		heightPart, ratePart, hasRate := strings.Cut(part, "@")
+
+		height, err := strconv.Atoi(strings.TrimSuffix(heightPart, "p"))
+		if err != nil || height < 144 || height > 2160 || height%2 != 0 {
+			return nil, fmt.Errorf("invalid rendition %q (use even heights between 144 and 2160, e.g. 720 or 720@2500)", part)
+		}
+		if seen[height] {
+			return nil, fmt.Errorf("duplicate rendition %dp", height)
+		}
+		seen[height] = true
+
+		kbps := defaultLadderBitrate(height)
+		if hasRate {
+			kbps, err = strconv.Atoi(strings.TrimSuffix(ratePart, "k"))
+			if err != nil || kbps < MinVideoBitrate || kbps > 50000 {
+				return nil, fmt.Errorf("invalid bitrate in rendition %q (kbps between %d and 50000)", part, MinVideoBitrate)
+			}
+		}
+		renditions = append(renditions, Rendition{Height: height, VideoKbps: kbps})
+	}
+
+	if len(renditions) > MaxRenditions {
+		return nil, fmt.Errorf("at most %d renditions are allowed", MaxRenditions)
+	}
+
+	sort.Slice(renditions, func(i, j int) bool { return renditions[i].Height > renditions[j].Height })
+	return renditions, nil
+}
+
+// defaultLadderBitrate picks the bitrate of the closest ladder step at or above height
+func defaultLadderBitrate(height int) int {
+	best, bestHeight := 12000, 1<<30
+	for h, kbps := range ladderBitrates {
+		if h >= height && h < bestHeight {
+			best, bestHeight = kbps, h
+		}
+	}
+	return best
+}
+
+// IsStreaming reports whether the job is packaged for adaptive streaming
+func (o ConversionOptions) IsStreaming() bool {
+	return o.OutputMode != OutputModeFile
+}
+
+// renditionsFor drops renditions taller than the source, keeping at least one
+func (o ConversionOptions) renditionsFor(info *MediaInfo) []Rendition {
+	if info == nil || info.VideoStream() == nil || info.VideoStream().Height == 0 {
+		return o.Renditions
+	}
+
+	source := info.VideoStream().Height
+	if o.Crop != nil {
+		source = o.Crop.Height
+	}
+	if o.MaxHeight > 0 && o.MaxHeight < source {
+		source = o.MaxHeight
+	}
+
+	renditions := make([]Rendition, 0)
+	for _, r := range o.Renditions {
+		if r.Height <= source {
+			renditions = append(renditions, r)
+		}
+	}
+	if len(renditions) == 0 {
+		renditions = append(renditions, o.Renditions[len(o.Renditions)-1])
+	}
+	return renditions
+}
+
+// streamDir holds the playlists and segments of a packaged job
+func streamDir(job *Job) string {
+	return filepath.Join(OutputDir, job.ID+"_stream")
+}
+
+// mediaOutputPath is what probes and previews read for a finished job
+func mediaOutputPath(job *Job) string {
+	if job.Options.IsStreaming() {
+		return filepath.Join(streamDir(job), "master.m3u8")
+	}
+	return filepath.Join(OutputDir, job.ID+"_"+job.OutputName)
+}
+
+// runPackaging encodes every rendition in one ffmpeg run, splitting the
+// filtered video so crop, scale and watermark are applied once
+func runPackaging(ctx context.Context, strategy ConversionStrategy, job *Job, input, output string, duration float64, progress ProgressFunc) (err error) {
+	dir := streamDir(job)
+	os.RemoveAll(dir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("cannot create stream dir: %v", err)
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	renditions := job.Options.renditionsFor(job.InputInfo)
+	// Only map audio a probe actually found: a missing 0:a:0 aborts ffmpeg,
+	// and -var_stream_map can't refer to an optional stream
+	hasAudio := job.InputInfo != nil && job.InputInfo.HasStream("audio") && !job.Options.Mute
+
+	graph := videoFilterComplex(job) + fmt.Sprintf(";[vout]split=%d", len(renditions))
+	for i := range renditions {
+		graph += fmt.Sprintf("[s%d]", i)
+	}
+	for i, r := range renditions {
+		graph += fmt.Sprintf(";[s%d]scale=-2:'min(ih,%d)'[v%d]", i, r.Height, i)
+	}
+
+	args := jobInputArgs(strategy, job, input)
+	args = append(args, job.Options.trimArgs()...)
+	args = append(args, "-filter_complex", graph)
+	for i := range renditions {
+		args = append(args, "-map", fmt.Sprintf("[v%d]", i))
+	}
+	args = append(args, strategy.OutputArgs...)
+	for i, r := range renditions {
+		idx := strconv.Itoa(i)
+		args = append(args,
+			"-b:v:"+idx, fmt.Sprintf("%dk", r.VideoKbps),
+			"-maxrate:v:"+idx, fmt.Sprintf("%dk", r.VideoKbps*107/100),
+			"-bufsize:v:"+idx, fmt.Sprintf("%dk", r.VideoKbps*3/2))
+	}
+	// Aligned keyframes so every rendition can switch at segment boundaries
+	args = append(args,
+		"-force_key_frames", fmt.Sprintf("expr:gte(t,n_forced*%d)", SegmentSeconds),
+		"-sc_threshold", "0")
+
+	if job.Options.OutputMode == OutputModeHLS {
+		streamMap := make([]string, 0, len(renditions))
+		for i := range renditions {
+			if hasAudio {
+				args = append(args, "-map", "0:a:0")
+				streamMap = append(streamMap, fmt.Sprintf("v:%d,a:%d", i, i))
+			} else {
+				streamMap = append(streamMap, fmt.Sprintf("v:%d", i))
+			}
+		}
+		if hasAudio {
+			args = append(args, "-c:a", "aac", "-b:a", StreamAudioBitrate, "-ac", "2")
+		}
+		args = append(args,
+			"-f", "hls",
+			"-hls_time", strconv.Itoa(SegmentSeconds),
+			"-hls_playlist_type", "vod",
+			"-hls_flags", "independent_segments",
+			"-hls_segment_filename", filepath.Join(dir, "stream_%v", "segment_%03d.ts"),
+			"-master_pl_name", "master.m3u8",
+			"-var_stream_map", strings.Join(streamMap, " "),
+			"-y", filepath.Join(dir, "stream_%v", "playlist.m3u8"))
+	} else {
+		adaptationSets := "id=0,streams=v"
+		if hasAudio {
+			args = append(args, "-map", "0:a:0", "-c:a", "aac", "-b:a", StreamAudioBitrate, "-ac", "2")
+			adaptationSets += " id=1,streams=a"
+		}
+		args = append(args,
+			"-f", "dash",
+			"-seg_duration", strconv.Itoa(SegmentSeconds),
+			"-use_template", "1",
+			"-use_timeline", "1",
+			"-hls_playlist", "1",
+			"-adaptation_sets", adaptationSets,
+			"-init_seg_name", "init_$RepresentationID$.m4s",
+			"-media_seg_name", "chunk_$RepresentationID$_$Number%05d$.m4s",
+			"-y", filepath.Join(dir, "manifest.mpd"))
+	}
+
+	if err := convertVideoWithProgress(ctx, args, duration, progress); err != nil {
+		return err
+	}
+
+	base := "/streams/" + job.ID + "/"
+	streaming := &StreamingOutput{
+		Mode:       job.Options.OutputMode,
+		Master:     base + "master.m3u8",
+		Renditions: renditions,
+	}
+	if job.Options.OutputMode == OutputModeDASH {
+		streaming.Manifest = base + "manifest.mpd"
+	}
+
+	queue.mu.Lock()
+	job.Streaming = streaming
+	queue.mu.Unlock()
+	return nil
+}
+
+var streamContentTypes = map[string]string{
+	".m3u8": "application/vnd.apple.mpegurl",
+	".mpd":  "application/dash+xml",
+	".ts":   "video/mp2t",
+	".m4s":  "video/iso.segment",
+	".mp4":  "video/mp4",
+}
+
+// handleStreamFile serves playlists and segments under /streams/{id}/
+func handleStreamFile(w http.ResponseWriter, r *http.Request) {
+	jobID := mux.Vars(r)["id"]
+
+	queue.mu.RLock()
+	job, exists := queue.completed[jobID]
+	queue.mu.RUnlock()
+
+	if !exists || !job.Options.IsStreaming() {
+		http.Error(w, "Stream not found", http.StatusNotFound)
+		return
+	}
+
+	rel := strings.TrimPrefix(r.URL.Path, "/streams/"+jobID+"/")
+	path := filepath.Join(streamDir(job), filepath.Clean("/"+rel))
+	contentType, ok := streamContentTypes[filepath.Ext(path)]
+	if !ok || !fileExists(path) {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	http.ServeFile(w, r, path)
+}
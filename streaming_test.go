@@ -0,0 +1,89 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRenditions(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []Rendition
+		wantErr bool
+	}{
+		{"720,480,360", []Rendition{{720, 2800}, {480, 1400}, {360, 800}}, false},
+		{"360, 1080p", []Rendition{{1080, 5000}, {360, 800}}, false},
+		{"720@2500", []Rendition{{720, 2500}}, false},
+		{"720@2500k,540", []Rendition{{720, 2500}, {540, 2800}}, false},
+		{"144", []Rendition{{144, 400}}, false},
+		{"2160", []Rendition{{2160, 12000}}, false},
+		{"721", nil, true},
+		{"142", nil, true},
+		{"2162", nil, true},
+		{"720,720", nil, true},
+		{"720@100", nil, true},
+		{"720@fast", nil, true},
+		{"1080,720,480,360,240,144", nil, true},
+		{"", nil, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseRenditions(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseRenditions(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseRenditions(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestDefaultLadderBitrate(t *testing.T) {
+	tests := []struct {
+		height int
+		want   int
+	}{
+		{144, 400},
+		{240, 400},
+		{360, 800},
+		{540, 2800},
+		{720, 2800},
+		{1080, 5000},
+		{1200, 8000},
+		{2160, 12000},
+	}
+
+	for _, tt := range tests {
+		if got := defaultLadderBitrate(tt.height); got != tt.want {
+			t.Errorf("defaultLadderBitrate(%d) = %d, want %d", tt.height, got, tt.want)
+		}
+	}
+}
+
+func TestRenditionsFor(t *testing.T) {
+	ladder := []Rendition{{1080, 5000}, {720, 2800}, {360, 800}}
+	info := func(height int) *MediaInfo {
+		return &MediaInfo{Streams: []StreamInfo{{Type: "video", Height: height}}}
+	}
+
+	tests := []struct {
+		name string
+		opts ConversionOptions
+		info *MediaInfo
+		want []Rendition
+	}{
+		{"not probed", ConversionOptions{Renditions: ladder}, nil, ladder},
+		{"tall source", ConversionOptions{Renditions: ladder}, info(1080), ladder},
+		{"drops taller", ConversionOptions{Renditions: ladder}, info(720), ladder[1:]},
+		{"keeps the smallest", ConversionOptions{Renditions: ladder}, info(240), ladder[2:]},
+		{"max height", ConversionOptions{Renditions: ladder, MaxHeight: 400}, info(1080), ladder[2:]},
+		{"crop", ConversionOptions{Renditions: ladder, Crop: &CropRect{Width: 1280, Height: 720}}, info(1080), ladder[1:]},
+	}
+
+	for _, tt := range tests {
+		if got := tt.opts.renditionsFor(tt.info); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: renditionsFor() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Sidecar subtitle limits
+const (
+	MaxSubtitleSize     = 2 * 1024 * 1024 // 2MB
+	MinSubtitleFontSize = 8
+	MaxSubtitleFontSize = 96
+)
+
+// Subtitle positions for burn-in, mapped to ASS numpad alignment
+var subtitleAlignments = map[string]int{
+	"bottom": 2,
+	"middle": 5,
+	"top":    8,
+}
+
+var (
+	srtCuePattern   = regexp.MustCompile(`\d{1,2}:\d{2}:\d{2}[,.]\d{3}\s*-->\s*\d{1,2}:\d{2}:\d{2}[,.]\d{3}`)
+	fontNamePattern = regexp.MustCompile(`^[A-Za-z0-9 _-]{1,64}$`)
+)
+
+// saveSubtitleSidecar validates an uploaded SRT/VTT/ASS file and stores it
+// next to the job input. It returns the stored path.
+func saveSubtitleSidecar(job *Job, file multipart.File, header *multipart.FileHeader) (string, error) {
+	ext := strings.ToLower(filepath.Ext(header.Filename))
+
+	data, err := io.ReadAll(io.LimitReader(file, MaxSubtitleSize+1))
+	if err != nil {
+		return "", err
+	}
+	if len(data) > MaxSubtitleSize {
+		return "", fmt.Errorf("subtitle file is larger than %d MB", MaxSubtitleSize/(1024*1024))
+	}
+
+	if err := validateSubtitle(ext, data); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(UploadDir, job.ID+"_subtitle"+ext)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// validateSubtitle checks that the content matches the declared format
+func validateSubtitle(ext string, data []byte) error {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	if !utf8.Valid(data) {
+		return fmt.Errorf("subtitle file must be UTF-8 encoded")
+	}
+	text := string(data)
+
+	switch ext {
+	case ".vtt":
+		if !strings.HasPrefix(text, "WEBVTT") {
+			return fmt.Errorf("VTT file must start with WEBVTT")
+		}
+	case ".srt":
+		if !srtCuePattern.MatchString(text) {
+			return fmt.Errorf("SRT file contains no cues")
+		}
+	case ".ass", ".ssa":
+		if !strings.Contains(text, "[Script Info]") || !strings.Contains(text, "[Events]") {
+			return fmt.Errorf("ASS file is missing [Script Info] or [Events]")
+		}
+	default:
+		return fmt.Errorf("subtitle file must be .srt, .vtt or .ass")
+	}
+	return nil
+}
+
+func (o *ConversionOptions) parseSubtitleStyle(get func(string) string) error {
+	if v := strings.TrimSpace(get("subtitle_font")); v != "" {
+		if !fontNamePattern.MatchString(v) {
+			return fmt.Errorf("subtitle_font may only contain letters, digits, spaces, _ and -")
+		}
+		o.SubtitleFont = v
+	}
+
+	if v := get("subtitle_size"); v != "" {
+		size, err := strconv.Atoi(v)
+		if err != nil || size < MinSubtitleFontSize || size > MaxSubtitleFontSize {
+			return fmt.Errorf("subtitle_size must be between %d and %d", MinSubtitleFontSize, MaxSubtitleFontSize)
+		}
+		o.SubtitleSize = size
+	}
+
+	if v := strings.ToLower(get("subtitle_position")); v != "" {
+		if _, ok := subtitleAlignments[v]; !ok {
+			return fmt.Errorf("subtitle_position must be bottom, middle or top")
+		}
+		o.SubtitlePosition = v
+	}
+
+	return nil
+}
+
+// subtitleForceStyle builds the force_style override for burn-in, or ""
+func (o ConversionOptions) subtitleForceStyle() string {
+	styles := make([]string, 0)
+	if o.SubtitleFont != "" {
+		styles = append(styles, "FontName="+o.SubtitleFont)
+	}
+	if o.SubtitleSize > 0 {
+		styles = append(styles, "FontSize="+strconv.Itoa(o.SubtitleSize))
+	}
+	if o.SubtitlePosition != "" {
+		styles = append(styles, "Alignment="+strconv.Itoa(subtitleAlignments[o.SubtitlePosition]))
+	}
+	return strings.Join(styles, ",")
+}
+
+// sidecarInputArgs adds the uploaded subtitle as a second input when it is
+// muxed as a soft track. It is seeked like the main input so it stays in sync.
+func sidecarInputArgs(job *Job) []string {
+	if job.SubtitleInputPath == "" || job.Options.Subtitles != SubtitlesSoft {
+		return nil
+	}
+	args := job.Options.seekArgs()
+	return append(args, "-i", job.SubtitleInputPath)
+}
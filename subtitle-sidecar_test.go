@@ -0,0 +1,57 @@
+package main
+
+import "testing"
+
+func TestValidateSubtitle(t *testing.T) {
+	const srt = "1\n00:00:01,000 --> 00:00:02,500\nHello\n"
+
+	tests := []struct {
+		ext     string
+		data    string
+		wantErr bool
+	}{
+		{".srt", srt, false},
+		{".srt", "\xef\xbb\xbf" + srt, false},
+		{".srt", "just some text", true},
+		{".vtt", "WEBVTT\n\n00:01.000 --> 00:02.000\nHi\n", false},
+		{".vtt", srt, true},
+		{".ass", "[Script Info]\nTitle: x\n[Events]\n", false},
+		{".ass", "[Script Info]\n", true},
+		{".srt", "1\n00:00:01,000 --> 00:00:02,500\n\xff\xfe\n", true},
+		{".txt", srt, true},
+	}
+
+	for _, tt := range tests {
+		if err := validateSubtitle(tt.ext, []byte(tt.data)); (err != nil) != tt.wantErr {
+			t.Errorf("validateSubtitle(%s, %q) error = %v, wantErr %v", tt.ext, tt.data, err, tt.wantErr)
+		}
+	}
+}
+
+func TestParseSubtitleStyle(t *testing.T) {
+	tests := []struct {
+		params    map[string]string
+		wantStyle string
+		wantErr   bool
+	}{
+		{map[string]string{}, "", false},
+		{map[string]string{"subtitle_font": "DejaVu Sans", "subtitle_size": "24", "subtitle_position": "Top"}, "FontName=DejaVu Sans,FontSize=24,Alignment=8", false},
+		{map[string]string{"subtitle_position": "bottom"}, "Alignment=2", false},
+		{map[string]string{"subtitle_font": "Arial',Outline=9"}, "", true},
+		{map[string]string{"subtitle_size": "4"}, "", true},
+		{map[string]string{"subtitle_size": "big"}, "", true},
+		{map[string]string{"subtitle_position": "left"}, "", true},
+	}
+
+	for _, tt := range tests {
+		var opts ConversionOptions
+		err := opts.parseSubtitleStyle(func(key string) string { return tt.params[key] })
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseSubtitleStyle(%v) error = %v, wantErr %v", tt.params, err, tt.wantErr)
+			continue
+		}
+		if got := opts.subtitleForceStyle(); !tt.wantErr && got != tt.wantStyle {
+			t.Errorf("subtitleForceStyle() for %v = %q, want %q", tt.params, got, tt.wantStyle)
+		}
+	}
+}
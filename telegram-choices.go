@@ -0,0 +1,336 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/google/uuid"
+)
+
+// TelegramChoiceTTL is how long a received video waits for the user to
+// pick options before it is discarded
+const TelegramChoiceTTL = 15 * time.Minute
+
+// telegramTrimPresets are offered when the video is longer than them
+var telegramTrimPresets = []int{15, 60}
+
+// telegramChoice is a downloaded video waiting for the user to pick the
+// output format, quality, trim and audio
+type telegramChoice struct {
+	ID       string
+	ChatID   int64
+	MsgID    int
+	FileName string
+	TempPath string
+	Duration float64
+	Values   map[string]string
+
+	awaitingTrim bool
+	timer        *time.Timer
+}
+
+var telegramChoices = struct {
+	sync.Mutex
+	byID map[string]*telegramChoice
+	trim map[int64]*telegramChoice // chat -> choice waiting for a trim reply
+}{
+	byID: make(map[string]*telegramChoice),
+	trim: make(map[int64]*telegramChoice),
+}
+
+// askTelegramChoices shows the option keyboard for a downloaded video. The
+// chat's defaults are preselected.
+func askTelegramChoices(chatID int64, msgID int, fileName, tempPath string, info *MediaInfo) {
+	choice := &telegramChoice{
+		ID:       uuid.New().String()[:8],
+		ChatID:   chatID,
+		MsgID:    msgID,
+		FileName: fileName,
+		TempPath: tempPath,
+		Duration: info.Duration,
+		Values:   defaultsStore.Get(telegramOwner(chatID)),
+	}
+
+	telegramChoices.Lock()
+	telegramChoices.byID[choice.ID] = choice
+	choice.timer = time.AfterFunc(TelegramChoiceTTL, func() { expireTelegramChoice(choice) })
+	text, markup := choice.render()
+	telegramChoices.Unlock()
+
+	telegramBot.Send(tgbotapi.NewEditMessageTextAndMarkup(chatID, msgID, text, markup))
+}
+
+// takeTelegramChoiceLocked forgets a choice. Caller must hold telegramChoices.
+func takeTelegramChoiceLocked(choice *telegramChoice) {
+	choice.timer.Stop()
+	delete(telegramChoices.byID, choice.ID)
+	if telegramChoices.trim[choice.ChatID] == choice {
+		delete(telegramChoices.trim, choice.ChatID)
+	}
+}
+
+func expireTelegramChoice(choice *telegramChoice) {
+	telegramChoices.Lock()
+	if telegramChoices.byID[choice.ID] != choice {
+		telegramChoices.Unlock()
+		return
+	}
+	takeTelegramChoiceLocked(choice)
+	telegramChoices.Unlock()
+
+	os.Remove(choice.TempPath)
+	editTelegramMessage(choice.ChatID, choice.MsgID, "⌛ No options were picked for "+choice.FileName+"; send it again to convert it")
+}
+
+// render builds the message text and keyboard. Caller must hold telegramChoices.
+func (c *telegramChoice) render() (string, tgbotapi.InlineKeyboardMarkup) {
+	button := func(label string, selected bool, action string) tgbotapi.InlineKeyboardButton {
+		if selected {
+			label = "✓ " + label
+		}
+		return tgbotapi.NewInlineKeyboardButtonData(label, "ch:"+c.ID+":"+action)
+	}
+
+	format := strings.ToLower(c.Values["format"])
+	quality := strings.ToLower(c.Values["quality"])
+	if quality == "" {
+		quality = QualityMedium
+	}
+	muted := c.Values["mute"] == "true"
+	trimmed := c.Values["start"] != "" || c.Values["end"] != "" || c.Values["duration"] != ""
+
+	trimRow := []tgbotapi.InlineKeyboardButton{button("Full", !trimmed, "trim:full")}
+	for _, seconds := range telegramTrimPresets {
+		if c.Duration == 0 || float64(seconds) < c.Duration {
+			preset := c.Values["start"] == "" && c.Values["end"] == "" && c.Values["duration"] == fmt.Sprint(seconds)
+			trimRow = append(trimRow, button(fmt.Sprintf("First %ds", seconds), preset, fmt.Sprintf("trim:%d", seconds)))
+		}
+	}
+	trimRow = append(trimRow, button("✂️ Custom", false, "trim:ask"))
+
+	muteLabel := "🔊 Keep sound"
+	if muted {
+		muteLabel = "🔇 Muted"
+	}
+
+	markup := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			button("MP4", format != FormatMKV, "format:mp4"),
+			button("MKV", format == FormatMKV, "format:mkv")),
+		tgbotapi.NewInlineKeyboardRow(
+			button("Low", quality == QualityLow, "quality:low"),
+			button("Medium", quality == QualityMedium, "quality:medium"),
+			button("High", quality == QualityHigh, "quality:high")),
+		trimRow,
+		tgbotapi.NewInlineKeyboardRow(button(muteLabel, false, "mute")),
+		tgbotapi.NewInlineKeyboardRow(
+			button("▶️ Convert", false, "go"),
+			button("⭐ Convert & remember", false, "save")),
+		tgbotapi.NewInlineKeyboardRow(button("✖️ Cancel", false, "cancel")),
+	)
+
+	text := "🎬 " + c.FileName
+	if c.Duration > 0 {
+		text += " (" + formatTimestamp(c.Duration) + ")"
+	}
+	text += "\n\nPick the output and tap Convert."
+	if trimmed {
+		text += "\n✂️ Keeping " + describeTrim(c.Values)
+	}
+	if c.awaitingTrim {
+		text += "\n\n✂️ Reply with the part to keep, e.g. 0:10-1:30 or start=5 duration=20"
+	}
+	return text, markup
+}
+
+// describeTrim summarises the trim values, e.g. "0:10 to 1:30"
+func describeTrim(values map[string]string) string {
+	start := values["start"]
+	if start == "" {
+		start = "0:00"
+	}
+	switch {
+	case values["end"] != "":
+		return start + " to " + values["end"]
+	case values["duration"] != "":
+		return values["duration"] + "s from " + start
+	}
+	return "from " + start
+}
+
+// handleTelegramCallback routes inline keyboard presses
+func handleTelegramCallback(query *tgbotapi.CallbackQuery) {
+	if query.Message == nil {
+		return
+	}
+	switch {
+	case strings.HasPrefix(query.Data, "ch:"):
+		handleTelegramChoiceCallback(query)
+		return
+	case strings.HasPrefix(query.Data, "rt:"):
+		handleTelegramRetryCallback(query)
+		return
+	case strings.HasPrefix(query.Data, "st:"):
+		handleTelegramSettingsCallback(query)
+		return
+	}
+	telegramBot.Request(tgbotapi.NewCallback(query.ID, ""))
+}
+
+// handleTelegramChoiceCallback applies one keyboard press, e.g.
+// "ch:1a2b3c4d:quality:high"
+func handleTelegramChoiceCallback(query *tgbotapi.CallbackQuery) {
+	parts := strings.SplitN(query.Data, ":", 4)
+	if len(parts) < 3 {
+		telegramBot.Request(tgbotapi.NewCallback(query.ID, ""))
+		return
+	}
+	action, value := parts[2], ""
+	if len(parts) == 4 {
+		value = parts[3]
+	}
+
+	telegramChoices.Lock()
+	choice := telegramChoices.byID[parts[1]]
+	if choice == nil || choice.ChatID != query.Message.Chat.ID {
+		telegramChoices.Unlock()
+		telegramBot.Request(tgbotapi.NewCallback(query.ID, "This video expired, send it again"))
+		return
+	}
+
+	switch action {
+	case "format", "quality":
+		choice.Values[action] = value
+	case "mute":
+		if choice.Values["mute"] == "true" {
+			delete(choice.Values, "mute")
+		} else {
+			choice.Values["mute"] = "true"
+		}
+	case "trim":
+		choice.awaitingTrim = value == "ask"
+		if choice.awaitingTrim {
+			telegramChoices.trim[choice.ChatID] = choice
+			break
+		}
+		delete(choice.Values, "start")
+		delete(choice.Values, "end")
+		delete(choice.Values, "duration")
+		if value != "full" {
+			choice.Values["duration"] = value
+		}
+	case "go", "save":
+		options, err := parseConversionOptions(func(key string) string { return choice.Values[key] })
+		if err != nil {
+			telegramChoices.Unlock()
+			telegramBot.Request(tgbotapi.NewCallbackWithAlert(query.ID, "❌ "+err.Error()))
+			return
+		}
+		takeTelegramChoiceLocked(choice)
+		telegramChoices.Unlock()
+
+		answer := ""
+		if action == "save" {
+			answer = rememberTelegramChoice(choice)
+		}
+		telegramBot.Request(tgbotapi.NewCallback(query.ID, answer))
+		queueTelegramFile(choice.ChatID, choice.MsgID, choice.FileName, choice.TempPath, options)
+		return
+	case "cancel":
+		takeTelegramChoiceLocked(choice)
+		telegramChoices.Unlock()
+
+		os.Remove(choice.TempPath)
+		telegramBot.Request(tgbotapi.NewCallback(query.ID, ""))
+		editTelegramMessage(choice.ChatID, choice.MsgID, "✖️ Cancelled "+choice.FileName)
+		return
+	}
+
+	text, markup := choice.render()
+	telegramChoices.Unlock()
+
+	telegramBot.Request(tgbotapi.NewCallback(query.ID, ""))
+	telegramBot.Send(tgbotapi.NewEditMessageTextAndMarkup(choice.ChatID, choice.MsgID, text, markup))
+}
+
+// rememberTelegramChoice saves the picked format, quality and sound as the
+// chat's defaults and stops asking. Trims are specific to one video.
+func rememberTelegramChoice(choice *telegramChoice) string {
+	owner := telegramOwner(choice.ChatID)
+	updates := map[string]string{
+		"format":  choice.Values["format"],
+		"quality": choice.Values["quality"],
+		"mute":    choice.Values["mute"],
+	}
+	if err := defaultsStore.Update(owner, updates); err != nil {
+		log.Printf("Telegram chat %d: saving defaults failed: %v", choice.ChatID, err)
+		return "Could not save your defaults"
+	}
+	if err := telegramChats.Update(choice.ChatID, func(s *TelegramChatSettings) { s.SkipQuestions = true }); err != nil {
+		log.Printf("Telegram chat %d: saving settings failed: %v", choice.ChatID, err)
+	}
+	return "Saved. Send /ask to see these options again."
+}
+
+// handleTelegramTrimReply takes a text message as the custom trim of the
+// chat's pending video. It reports false if no video is waiting for one.
+func handleTelegramTrimReply(message *tgbotapi.Message) bool {
+	chatID := message.Chat.ID
+
+	telegramChoices.Lock()
+	choice := telegramChoices.trim[chatID]
+	if choice == nil {
+		telegramChoices.Unlock()
+		return false
+	}
+
+	values := captionValues(message.Text)
+	trim := map[string]string{}
+	for _, key := range []string{"start", "end", "duration"} {
+		if values[key] != "" {
+			trim[key] = values[key]
+		}
+	}
+
+	reply := ""
+	if len(trim) == 0 {
+		reply = "❌ Send the part to keep like 0:10-1:30, or start=5 duration=20"
+	} else if options, err := parseConversionOptions(func(key string) string { return trim[key] }); err != nil {
+		reply = "❌ " + err.Error()
+	} else if choice.Duration > 0 && options.TrimStart >= choice.Duration {
+		reply = fmt.Sprintf("❌ The video is only %s long", formatTimestamp(choice.Duration))
+	}
+	if reply != "" {
+		telegramChoices.Unlock()
+		telegramBot.Send(tgbotapi.NewMessage(chatID, reply))
+		return true
+	}
+
+	delete(choice.Values, "start")
+	delete(choice.Values, "end")
+	delete(choice.Values, "duration")
+	for key, value := range trim {
+		choice.Values[key] = value
+	}
+	choice.awaitingTrim = false
+	delete(telegramChoices.trim, chatID)
+	text, markup := choice.render()
+	telegramChoices.Unlock()
+
+	telegramBot.Send(tgbotapi.NewEditMessageTextAndMarkup(chatID, choice.MsgID, text, markup))
+	return true
+}
+
+// handleTelegramAsk turns the option questions back on for the chat
+func handleTelegramAsk(chatID int64) {
+	if err := telegramChats.Update(chatID, func(s *TelegramChatSettings) { s.SkipQuestions = false }); err != nil {
+		telegramBot.Send(tgbotapi.NewMessage(chatID, "❌ "+err.Error()))
+		return
+	}
+	telegramBot.Send(tgbotapi.NewMessage(chatID, "✅ I'll ask for the format and quality again with your next video"))
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDescribeTrim(t *testing.T) {
+	tests := []struct {
+		values map[string]string
+		want   string
+	}{
+		{map[string]string{"start": "0:10", "end": "1:30"}, "0:10 to 1:30"},
+		{map[string]string{"end": "5"}, "0:00 to 5"},
+		{map[string]string{"duration": "15"}, "15s from 0:00"},
+		{map[string]string{"start": "3", "duration": "20"}, "20s from 3"},
+		{map[string]string{"start": "42"}, "from 42"},
+	}
+
+	for _, tt := range tests {
+		if got := describeTrim(tt.values); got != tt.want {
+			t.Errorf("describeTrim(%v) = %q, want %q", tt.values, got, tt.want)
+		}
+	}
+}
+
+func TestTelegramChoiceRender(t *testing.T) {
+	choice := &telegramChoice{
+		ID:       "c1",
+		FileName: "clip.webm",
+		Duration: 30,
+		Values:   map[string]string{"format": "mkv", "mute": "true", "duration": "15"},
+	}
+	text, markup := choice.render()
+
+	labels := make(map[string]string)
+	for _, row := range markup.InlineKeyboard {
+		for _, button := range row {
+			labels[*button.CallbackData] = button.Text
+		}
+	}
+
+	tests := map[string]string{
+		"ch:c1:format:mkv":     "✓ MKV",
+		"ch:c1:format:mp4":     "MP4",
+		"ch:c1:quality:medium": "✓ Medium",
+		"ch:c1:trim:15":        "✓ First 15s",
+		"ch:c1:trim:full":      "Full",
+		"ch:c1:mute":           "🔇 Muted",
+	}
+	for data, want := range tests {
+		if got := labels[data]; got != want {
+			t.Errorf("button %s = %q, want %q", data, got, want)
+		}
+	}
+	if _, ok := labels["ch:c1:trim:60"]; ok {
+		t.Error("offered a 60s trim of a 30s video")
+	}
+	if !strings.Contains(text, "Keeping 15s from 0:00") {
+		t.Errorf("text does not describe the trim: %q", text)
+	}
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Telegram /jobs and job ID settings
+const (
+	TelegramJobsListed    = 10
+	TelegramJobIDPrefix   = 8 // characters of a job ID shown and accepted
+	MinTelegramJobPrefix  = 4
+	TelegramJobErrorRunes = 80 // of an error in /jobs; messages are capped at 4096
+)
+
+// Output naming modes for /settings, the same as the web form's rename options
+const (
+	NamingOriginal = "original"
+	NamingPrefix   = "prefix"
+	NamingDate     = "date"
+)
+
+// telegramCommands are registered with setMyCommands and listed by /help
+var telegramCommands = []tgbotapi.BotCommand{
+	{Command: "start", Description: "What this bot does"},
+	{Command: "help", Description: "List the commands"},
+	{Command: "jobs", Description: "Your recent conversions"},
+	{Command: "cancel", Description: "Cancel a conversion: /cancel <id>"},
+	{Command: "retry", Description: "Retry a failed conversion: /retry <id>"},
+	{Command: "settings", Description: "Default format, quality and naming"},
+	{Command: "limits", Description: "File size limits and remaining quota"},
+	{Command: "status", Description: "Server queue status"},
+	{Command: "watermark", Description: "Set a default watermark"},
+	{Command: "ask", Description: "Ask for options with every video again"},
+}
+
+// registerTelegramCommands publishes the command menu
+func registerTelegramCommands() {
+	if _, err := telegramBot.Request(tgbotapi.NewSetMyCommands(telegramCommands...)); err != nil {
+		log.Printf("Registering Telegram commands failed: %v", err)
+	}
+}
+
+// escapeMarkdownV2 escapes text for MarkdownV2, including backslashes
+func escapeMarkdownV2(text string) string {
+	return tgbotapi.EscapeText(tgbotapi.ModeMarkdownV2, strings.ReplaceAll(text, `\`, `\\`))
+}
+
+// sendTelegramMarkdown sends a MarkdownV2 reply; callers escape user text
+func sendTelegramMarkdown(chatID int64, text string, markup *tgbotapi.InlineKeyboardMarkup) {
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ParseMode = tgbotapi.ModeMarkdownV2
+	if markup != nil {
+		msg.ReplyMarkup = markup
+	}
+	if _, err := telegramBot.Send(msg); err != nil {
+		log.Printf("Telegram chat %d: reply failed: %v", chatID, err)
+	}
+}
+
+// handleTelegramCommand answers a /command message
+func handleTelegramCommand(message *tgbotapi.Message) {
+	chatID := message.Chat.ID
+	args := strings.TrimSpace(message.CommandArguments())
+
+	switch message.Command() {
+	case "start":
+		sendTelegramMarkdown(chatID, "🎥 *WebM to MP4 Converter*\n\n"+
+			escapeMarkdownV2("Send me a video file, video, video note or GIF, or paste a link to one, and I'll convert it to MP4!")+"\n\n"+
+			escapeMarkdownV2("I'll ask for the format, quality, trim and sound, or add a caption like ")+"`0:10-1:30 quality=high`"+
+			escapeMarkdownV2(" to skip the questions. /settings changes your defaults.")+"\n\n"+
+			escapeMarkdownV2("Brand your clips with ")+"`/watermark your text`"+
+			escapeMarkdownV2(", or send a PNG with the caption /watermark. /help lists every command."), nil)
+	case "help":
+		lines := []string{"*Commands*"}
+		for _, command := range telegramCommands {
+			lines = append(lines, escapeMarkdownV2("/"+command.Command+" - "+command.Description))
+		}
+		sendTelegramMarkdown(chatID, strings.Join(lines, "\n"), nil)
+	case "status":
+		queue.mu.RLock()
+		q := len(queue.jobs)
+		p := len(queue.processing)
+		queue.mu.RUnlock()
+		sendTelegramMarkdown(chatID, escapeMarkdownV2(fmt.Sprintf("📊 Queue: %d | Processing: %d/%d", q, p, MaxConcurrent)), nil)
+	case "jobs":
+		handleTelegramJobs(chatID)
+	case "cancel", "retry":
+		handleTelegramJobCommand(chatID, message.Command(), args)
+	case "settings":
+		text, markup := renderTelegramSettings(chatID)
+		sendTelegramMarkdown(chatID, text, &markup)
+	case "limits":
+		sendTelegramMarkdown(chatID, telegramLimitsText(chatID), nil)
+	case "watermark":
+		handleTelegramWatermark(chatID, args)
+	case "ask":
+		handleTelegramAsk(chatID)
+	default:
+		sendTelegramMarkdown(chatID, escapeMarkdownV2("Unknown command, see /help"), nil)
+	}
+}
+
+// telegramJobsLocked returns the chat's jobs, newest first. Caller must hold queue.mu.
+func telegramJobsLocked(chatID int64) []*Job {
+	owner := telegramOwner(chatID)
+	jobs := make([]*Job, 0)
+	add := func(job *Job) {
+		if job.Owner == owner {
+			jobs = append(jobs, job)
+		}
+	}
+
+	for _, job := range queue.jobs {
+		add(job)
+	}
+	for _, job := range queue.processing {
+		add(job)
+	}
+	for _, job := range queue.completed {
+		add(job)
+	}
+	for _, job := range queue.failed {
+		add(job)
+	}
+
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].CreatedAt.After(jobs[j].CreatedAt) })
+	return jobs
+}
+
+func shortJobID(id string) string {
+	if len(id) > TelegramJobIDPrefix {
+		return id[:TelegramJobIDPrefix]
+	}
+	return id
+}
+
+var telegramStatusIcons = map[string]string{
+	"downloading": "⬇️",
+	"queued":      "📥",
+	"processing":  "🔄",
+	"completed":   "✅",
+	"failed":      "❌",
+	"cancelled":   "🚫",
+}
+
+// shortJobError cuts an error to its first line and TelegramJobErrorRunes
+func shortJobError(text string) string {
+	text, _, _ = strings.Cut(strings.TrimSpace(text), "\n")
+	if runes := []rune(text); len(runes) > TelegramJobErrorRunes {
+		text = strings.TrimSpace(string(runes[:TelegramJobErrorRunes-1])) + "…"
+	}
+	return text
+}
+
+func handleTelegramJobs(chatID int64) {
+	queue.mu.RLock()
+	jobs := telegramJobsLocked(chatID)
+	lines := make([]string, 0, TelegramJobsListed+2)
+	for i, job := range jobs {
+		if i == TelegramJobsListed {
+			break
+		}
+		detail := job.Status
+		switch job.Status {
+		case "queued":
+			detail = fmt.Sprintf("queued #%d", job.QueuePos)
+		case "processing":
+			detail = fmt.Sprintf("processing %d%%", job.Progress)
+		case "failed":
+			detail = "failed: " + shortJobError(job.Error)
+		}
+		lines = append(lines, fmt.Sprintf("%s `%s` %s", telegramStatusIcons[job.Status], shortJobID(job.ID),
+			escapeMarkdownV2(job.OutputName+" - "+detail)))
+	}
+	queue.mu.RUnlock()
+
+	if len(lines) == 0 {
+		sendTelegramMarkdown(chatID, escapeMarkdownV2("You have no recent jobs. Send me a video to start one."), nil)
+		return
+	}
+	text := "*Your recent jobs*\n" + strings.Join(lines, "\n") + "\n\n" +
+		escapeMarkdownV2("Use /cancel <id> or /retry <id> with the ID shown.")
+	sendTelegramMarkdown(chatID, text, nil)
+}
+
+// findTelegramJob finds one of the chat's jobs by an ID prefix
+func findTelegramJob(chatID int64, prefix string) (*Job, error) {
+	prefix = strings.ToLower(prefix)
+	if len(prefix) < MinTelegramJobPrefix {
+		return nil, fmt.Errorf("give at least %d characters of the job ID, see /jobs", MinTelegramJobPrefix)
+	}
+
+	queue.mu.RLock()
+	defer queue.mu.RUnlock()
+
+	var found *Job
+	for _, job := range telegramJobsLocked(chatID) {
+		if strings.HasPrefix(job.ID, prefix) {
+			if found != nil {
+				return nil, fmt.Errorf("%s matches several jobs, give more of the ID", prefix)
+			}
+			found = job
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("no job %s, see /jobs", prefix)
+	}
+	return found, nil
+}
+
+// handleTelegramJobCommand runs /cancel <id> or /retry <id>
+func handleTelegramJobCommand(chatID int64, command, args string) {
+	if args == "" {
+		sendTelegramMarkdown(chatID, escapeMarkdownV2("Usage: /"+command+" <id>, with an ID from /jobs"), nil)
+		return
+	}
+
+	job, err := findTelegramJob(chatID, strings.Fields(args)[0])
+	if err != nil {
+		sendTelegramMarkdown(chatID, escapeMarkdownV2("❌ "+err.Error()), nil)
+		return
+	}
+
+	if command == "cancel" {
+		if !cancelJob(job) {
+			sendTelegramMarkdown(chatID, escapeMarkdownV2("❌ That job already finished"), nil)
+			return
+		}
+		sendTelegramMarkdown(chatID, "🚫 Cancelled `"+shortJobID(job.ID)+"`", nil)
+		return
+	}
+
+	if err := retryJob(job); err != nil {
+		sendTelegramMarkdown(chatID, escapeMarkdownV2("❌ "+err.Error()), nil)
+		return
+	}
+	sendTelegramMarkdown(chatID, "🔁 Retrying `"+shortJobID(job.ID)+"`", nil)
+}
+
+// renderTelegramSettings builds the /settings message and keyboard
+func renderTelegramSettings(chatID int64) (string, tgbotapi.InlineKeyboardMarkup) {
+	defaults := defaultsStore.Get(telegramOwner(chatID))
+	settings := telegramChats.Get(chatID)
+
+	format := strings.ToLower(defaults["format"])
+	if format == "" {
+		format = FormatMP4
+	}
+	quality := strings.ToLower(defaults["quality"])
+	if quality == "" {
+		quality = QualityMedium
+	}
+	naming := settings.Naming
+	if naming == "" {
+		naming = NamingOriginal
+	}
+
+	button := func(label string, selected bool, data string) tgbotapi.InlineKeyboardButton {
+		if selected {
+			label = "✓ " + label
+		}
+		return tgbotapi.NewInlineKeyboardButtonData(label, "st:"+data)
+	}
+	askLabel := "❓ Ask with each video"
+	if settings.SkipQuestions {
+		askLabel = "⏩ Convert straight away"
+	}
+
+	markup := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			button("MP4", format == FormatMP4, "format:mp4"),
+			button("MKV", format == FormatMKV, "format:mkv")),
+		tgbotapi.NewInlineKeyboardRow(
+			button("Low", quality == QualityLow, "quality:low"),
+			button("Medium", quality == QualityMedium, "quality:medium"),
+			button("High", quality == QualityHigh, "quality:high")),
+		tgbotapi.NewInlineKeyboardRow(
+			button("Original name", naming == NamingOriginal, "naming:original"),
+			button("converted_", naming == NamingPrefix, "naming:prefix"),
+			button("Date", naming == NamingDate, "naming:date")),
+		tgbotapi.NewInlineKeyboardRow(button(askLabel, false, "ask")),
+	)
+
+	text := "⚙️ *Defaults for new videos*\n" + escapeMarkdownV2(fmt.Sprintf(
+		"Format: %s · Quality: %s · Names: %s", strings.ToUpper(format), quality, naming))
+	return text, markup
+}
+
+// handleTelegramSettingsCallback applies a /settings button, e.g. "st:quality:high"
+func handleTelegramSettingsCallback(query *tgbotapi.CallbackQuery) {
+	chatID := query.Message.Chat.ID
+	key, value, _ := strings.Cut(strings.TrimPrefix(query.Data, "st:"), ":")
+
+	var err error
+	switch key {
+	case "format", "quality":
+		values := map[string]string{key: value}
+		if _, err = parseConversionOptions(func(k string) string { return values[k] }); err == nil {
+			err = defaultsStore.Update(telegramOwner(chatID), values)
+		}
+	case "naming":
+		if value != NamingOriginal && value != NamingPrefix && value != NamingDate {
+			err = fmt.Errorf("unknown naming %q", value)
+			break
+		}
+		err = telegramChats.Update(chatID, func(s *TelegramChatSettings) {
+			s.Naming = value
+			if value == NamingOriginal {
+				s.Naming = ""
+			}
+		})
+	case "ask":
+		err = telegramChats.Update(chatID, func(s *TelegramChatSettings) { s.SkipQuestions = !s.SkipQuestions })
+	}
+	if err != nil {
+		telegramBot.Request(tgbotapi.NewCallbackWithAlert(query.ID, "❌ "+err.Error()))
+		return
+	}
+	telegramBot.Request(tgbotapi.NewCallback(query.ID, "Saved"))
+
+	text, markup := renderTelegramSettings(chatID)
+	edit := tgbotapi.NewEditMessageTextAndMarkup(chatID, query.Message.MessageID, text, markup)
+	edit.ParseMode = tgbotapi.ModeMarkdownV2
+	if _, err := telegramBot.Send(edit); err != nil && !strings.Contains(err.Error(), "message is not modified") {
+		log.Printf("Telegram chat %d: updating settings failed: %v", chatID, err)
+	}
+}
+
+// telegramOutputName names a Telegram job's output per the chat's naming setting
+func telegramOutputName(chatID int64, fileName string, options ConversionOptions) string {
+	base := strings.TrimSuffix(fileName, filepath.Ext(fileName))
+	switch telegramChats.Get(chatID).Naming {
+	case NamingPrefix:
+		base = "converted_" + base
+	case NamingDate:
+		base = time.Now().Format("2006-01-02") + "_" + base
+	}
+	return base + options.OutputExtension()
+}
+
+// telegramLimitsText describes the file limits and the chat's remaining quota
+func telegramLimitsText(chatID int64) string {
+	fileLimit := int64(MaxFileSize)
+	if telegramDownloadLimit < fileLimit {
+		fileLimit = telegramDownloadLimit
+	}
+
+	lines := []string{"*Limits*"}
+	if telegramDailyLimit > 0 {
+		used, next := telegramQuotaUsage(chatID)
+		line := fmt.Sprintf("Conversions in the last 24 hours: %d of %d used", used, telegramDailyLimit)
+		if used >= telegramDailyLimit {
+			line += fmt.Sprintf(", the next frees up in %s", time.Until(next).Round(time.Minute))
+		}
+		lines = append(lines, escapeMarkdownV2(line))
+	} else {
+		lines = append(lines, escapeMarkdownV2("Conversions: no daily limit"))
+	}
+
+	results := fmt.Sprintf("Results: up to %s sent here", telegramSizeLimitText(telegramUploadLimit))
+	if publicURL != "" {
+		results += ", larger ones as a download link"
+	} else {
+		results += "; larger ones cannot be delivered"
+	}
+
+	queue.mu.RLock()
+	active := 0
+	for _, job := range telegramJobsLocked(chatID) {
+		if job.Status == "queued" || job.Status == "processing" {
+			active++
+		}
+	}
+	queue.mu.RUnlock()
+
+	lines = append(lines,
+		escapeMarkdownV2(fmt.Sprintf("Videos you send: up to %s", telegramSizeLimitText(fileLimit))),
+		escapeMarkdownV2(fmt.Sprintf("Links: up to %s", telegramSizeLimitText(MaxFileSize))),
+		escapeMarkdownV2(results),
+		escapeMarkdownV2(fmt.Sprintf("Your active jobs: %d", active)))
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEscapeMarkdownV2(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"plain text", "plain text"},
+		{"my_clip_1.mp4", `my\_clip\_1\.mp4`},
+		{"*bold* [link](url) `code`", "\\*bold\\* \\[link\\]\\(url\\) \\`code\\`"},
+		{"a-b+c=d|e{f}g!h#i>j~k", `a\-b\+c\=d\|e\{f\}g\!h\#i\>j\~k`},
+		{`back\slash`, `back\\slash`},
+		{"🎬 émoji", "🎬 émoji"},
+	}
+
+	for _, tt := range tests {
+		if got := escapeMarkdownV2(tt.in); got != tt.want {
+			t.Errorf("escapeMarkdownV2(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestFindTelegramJob(t *testing.T) {
+	jobs := []*Job{
+		{ID: "abcd1234-0000", Owner: telegramOwner(1), CreatedAt: time.Now()},
+		{ID: "abcd5678-0000", Owner: telegramOwner(1), CreatedAt: time.Now()},
+		{ID: "ffff0000-0000", Owner: telegramOwner(1), CreatedAt: time.Now()},
+		{ID: "eeee0000-0000", Owner: telegramOwner(2), CreatedAt: time.Now()},
+	}
+	queue.mu.Lock()
+	queue.jobs = append(queue.jobs, jobs...)
+	queue.mu.Unlock()
+	defer func() {
+		queue.mu.Lock()
+		queue.jobs = queue.jobs[:len(queue.jobs)-len(jobs)]
+		queue.mu.Unlock()
+	}()
+
+	tests := []struct {
+		prefix  string
+		want    string // job ID, empty for an error
+		wantErr string
+	}{
+		{"abcd1234", "abcd1234-0000", ""},
+		{"ABCD5", "abcd5678-0000", ""},
+		{"ffff", "ffff0000-0000", ""},
+		{"abcd", "", "several jobs"},
+		{"abc", "", "at least 4"},
+		{"", "", "at least 4"},
+		{"1234", "", "no job"},
+		{"eeee", "", "no job"}, // another chat's job
+	}
+
+	for _, tt := range tests {
+		job, err := findTelegramJob(1, tt.prefix)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("findTelegramJob(%q) error = %v, want %q", tt.prefix, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || job.ID != tt.want {
+			t.Errorf("findTelegramJob(%q) = %v, %v; want %s", tt.prefix, job, err, tt.want)
+		}
+	}
+}
+
+func TestTelegramQuota(t *testing.T) {
+	defer func(limit int) { telegramDailyLimit = limit }(telegramDailyLimit)
+	const chatID = 42
+
+	telegramDailyLimit = 0
+	for i := 0; i < 5; i++ {
+		if err := useTelegramQuota(chatID); err != nil {
+			t.Fatalf("unlimited quota refused: %v", err)
+		}
+	}
+
+	telegramDailyLimit = 2
+	telegramUsage.Lock()
+	telegramUsage.chats[chatID] = []time.Time{time.Now().Add(-25 * time.Hour)}
+	telegramUsage.Unlock()
+
+	if err := checkTelegramQuota(chatID, 3); err == nil {
+		t.Error("checkTelegramQuota() allowed more than the limit")
+	}
+	if err := useTelegramQuota(chatID); err != nil {
+		t.Errorf("first conversion refused: %v", err)
+	}
+	if err := useTelegramQuota(chatID); err != nil {
+		t.Errorf("second conversion refused: %v", err)
+	}
+	if err := useTelegramQuota(chatID); err == nil {
+		t.Error("third conversion allowed")
+	}
+	if used, _ := telegramQuotaUsage(chatID); used != 2 {
+		t.Errorf("used = %d, want 2 after the expired entry was dropped", used)
+	}
+}
+
+func TestTelegramOutputName(t *testing.T) {
+	defer func(store *TelegramChatStore) { telegramChats = store }(telegramChats)
+	telegramChats = NewTelegramChatStore(filepath.Join(t.TempDir(), "chats.json"))
+
+	if got := telegramOutputName(1, "clip.webm", ConversionOptions{}); got != "clip.mp4" {
+		t.Errorf("original naming = %q", got)
+	}
+	telegramChats.Update(1, func(s *TelegramChatSettings) { s.Naming = NamingPrefix })
+	if got := telegramOutputName(1, "clip.webm", ConversionOptions{Format: FormatMKV}); got != "converted_clip.mkv" {
+		t.Errorf("prefix naming = %q", got)
+	}
+	telegramChats.Update(1, func(s *TelegramChatSettings) { s.Naming = NamingDate })
+	if got := telegramOutputName(1, "clip.webm", ConversionOptions{}); got != time.Now().Format("2006-01-02")+"_clip.mp4" {
+		t.Errorf("date naming = %q", got)
+	}
+}
+
+func TestShortJobError(t *testing.T) {
+	long := strings.Repeat("é", TelegramJobErrorRunes+20)
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"Cancelled", "Cancelled"},
+		{"corrupt_input: Invalid data\nfast: timeout\nsafe: killed", "corrupt_input: Invalid data"},
+		{long, strings.Repeat("é", TelegramJobErrorRunes-1) + "…"},
+	}
+
+	for _, tt := range tests {
+		got := shortJobError(tt.in)
+		if got != tt.want {
+			t.Errorf("shortJobError(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+		if n := len([]rune(got)); n > TelegramJobErrorRunes {
+			t.Errorf("shortJobError() kept %d runes", n)
+		}
+	}
+}
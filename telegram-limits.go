@@ -0,0 +1,243 @@
+package main
+
+import (
+	"crypto/hmac"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/gorilla/mux"
+)
+
+// Bot API file limits. A self-hosted Bot API server raises both.
+const (
+	TelegramDownloadLimit      = 20 * 1024 * 1024
+	TelegramUploadLimit        = 50 * 1024 * 1024
+	TelegramLocalServerLimit   = 2000 * 1024 * 1024
+	DefaultTelegramAPIEndpoint = "https://api.telegram.org"
+)
+
+// Telegram settings from the environment, see loadTelegramConfig
+var (
+	telegramAPIURL              = DefaultTelegramAPIEndpoint
+	telegramDownloadLimit int64 = TelegramDownloadLimit
+	telegramUploadLimit   int64 = TelegramUploadLimit
+	publicURL             string
+	telegramDailyLimit    int
+)
+
+// TelegramQuotaWindow is the period telegramDailyLimit counts conversions over
+const TelegramQuotaWindow = 24 * time.Hour
+
+// telegramUsage holds when each chat queued its recent conversions
+var telegramUsage = struct {
+	sync.Mutex
+	chats map[int64][]time.Time
+}{
+	chats: make(map[int64][]time.Time),
+}
+
+// loadTelegramConfig reads TELEGRAM_API_URL, the base URL of a self-hosted
+// Bot API server (e.g. http://localhost:8081), and PUBLIC_URL, the address
+// of this server used in links the bot sends
+func loadTelegramConfig() {
+	publicURL = strings.TrimSuffix(os.Getenv("PUBLIC_URL"), "/")
+
+	// TELEGRAM_DAILY_LIMIT caps the conversions per chat per day; 0 is unlimited
+	if v := os.Getenv("TELEGRAM_DAILY_LIMIT"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			telegramDailyLimit = n
+		} else {
+			log.Printf("Warning: ignoring invalid TELEGRAM_DAILY_LIMIT %q", v)
+		}
+	}
+
+	endpoint := strings.TrimSuffix(os.Getenv("TELEGRAM_API_URL"), "/")
+	if endpoint == "" || endpoint == DefaultTelegramAPIEndpoint {
+		return
+	}
+	telegramAPIURL = endpoint
+	telegramDownloadLimit = TelegramLocalServerLimit
+	telegramUploadLimit = TelegramLocalServerLimit
+	log.Printf("Telegram Bot API server: %s", telegramAPIURL)
+}
+
+// newTelegramBot connects to the configured Bot API server
+func newTelegramBot(token string) (*tgbotapi.BotAPI, error) {
+	return tgbotapi.NewBotAPIWithAPIEndpoint(token, telegramAPIURL+"/bot%s/%s")
+}
+
+// telegramSizeLimitText explains a size limit, e.g. "20 MB"
+func telegramSizeLimitText(limit int64) string {
+	return fmt.Sprintf("%d MB", limit/(1024*1024))
+}
+
+// fetchTelegramFile downloads a file sent to the bot to path. A Bot API
+// server in --local mode returns absolute paths on its own disk, which is
+// shared with this server.
+func fetchTelegramFile(fileID, path string) error {
+	file, err := telegramBot.GetFile(tgbotapi.FileConfig{FileID: fileID})
+	if err != nil {
+		if strings.Contains(err.Error(), "file is too big") {
+			return fmt.Errorf("Telegram only lets bots download files up to %s", telegramSizeLimitText(telegramDownloadLimit))
+		}
+		return err
+	}
+
+	if filepath.IsAbs(file.FilePath) {
+		in, err := os.Open(file.FilePath)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+
+		out, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+
+		_, err = io.Copy(out, in)
+		return err
+	}
+
+	return downloadFileFromURL(telegramAPIURL+"/file/bot"+telegramBot.Token+"/"+file.FilePath, path)
+}
+
+// signedJobDownloadURL returns an expiring link to a job's output, or ""
+// when PUBLIC_URL is not set
+func signedJobDownloadURL(jobID string) string {
+	if publicURL == "" {
+		return ""
+	}
+
+	expires := time.Now().Add(DefaultDownloadTTL).Unix()
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expires, 10))
+	query.Set("sig", signDownload([]string{jobID}, expires))
+	return publicURL + "/api/jobs/" + jobID + "/download/signed?" + query.Encode()
+}
+
+// checkDownloadSignature validates the expires/sig parameters of a single
+// job download link, writing the error if they are missing or invalid
+func checkDownloadSignature(w http.ResponseWriter, r *http.Request, jobID string) bool {
+	query := r.URL.Query()
+	if query.Get("sig") == "" {
+		http.Error(w, "Signature required", http.StatusForbidden)
+		return false
+	}
+
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		http.Error(w, "Download link expired", http.StatusGone)
+		return false
+	}
+	if !hmac.Equal([]byte(signDownload([]string{jobID}, expires)), []byte(query.Get("sig"))) {
+		http.Error(w, "Invalid signature", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// handleSignedJobDownload serves the links the bot sends,
+// GET /api/jobs/{id}/download/signed?expires=..&sig=..
+func handleSignedJobDownload(w http.ResponseWriter, r *http.Request) {
+	jobID := mux.Vars(r)["id"]
+	if !checkDownloadSignature(w, r, jobID) {
+		return
+	}
+
+	queue.mu.RLock()
+	job, exists := queue.completed[jobID]
+	queue.mu.RUnlock()
+
+	if !exists {
+		http.Error(w, "Job not found or not completed", http.StatusNotFound)
+		return
+	}
+	serveJobDownload(w, r, job)
+}
+
+// sendTelegramDownloadLink replaces an upload that Telegram would reject
+func sendTelegramDownloadLink(job *Job, outputName string, size int64) {
+	text := fmt.Sprintf("✅ Done! %s is %.1f MB, more than the %s bots may upload to Telegram.",
+		outputName, float64(size)/(1024*1024), telegramSizeLimitText(telegramUploadLimit))
+
+	if link := signedJobDownloadURL(job.ID); link != "" {
+		text += fmt.Sprintf("\n\n📥 Download it within %d minutes:\n%s", int(DefaultDownloadTTL.Minutes()), link)
+	} else {
+		text += "\n\nIt is too large to deliver here, and this bot has no download links. Try a lower quality or a shorter clip."
+	}
+	editTelegramMessage(job.TelegramChatID, job.TelegramMsgID, text)
+}
+
+// telegramQuotaUsageLocked drops expired usage and returns the chat's
+// conversions in the window. Caller must hold telegramUsage.
+func telegramQuotaUsageLocked(chatID int64) []time.Time {
+	cutoff := time.Now().Add(-TelegramQuotaWindow)
+	times := telegramUsage.chats[chatID]
+	for len(times) > 0 && times[0].Before(cutoff) {
+		times = times[1:]
+	}
+	if len(times) == 0 {
+		delete(telegramUsage.chats, chatID)
+	} else {
+		telegramUsage.chats[chatID] = times
+	}
+	return times
+}
+
+// telegramQuotaUsage reports the chat's conversions in the window and when
+// the oldest of them stops counting
+func telegramQuotaUsage(chatID int64) (int, time.Time) {
+	telegramUsage.Lock()
+	defer telegramUsage.Unlock()
+
+	times := telegramQuotaUsageLocked(chatID)
+	if len(times) == 0 {
+		return 0, time.Now()
+	}
+	return len(times), times[0].Add(TelegramQuotaWindow)
+}
+
+// checkTelegramQuota fails if the chat cannot queue n more conversions
+func checkTelegramQuota(chatID int64, n int) error {
+	if telegramDailyLimit == 0 {
+		return nil
+	}
+	used, next := telegramQuotaUsage(chatID)
+	if used+n <= telegramDailyLimit {
+		return nil
+	}
+	if left := telegramDailyLimit - used; left > 0 {
+		return fmt.Errorf("you can convert %d more video(s) today", left)
+	}
+	return fmt.Errorf("you reached the limit of %d conversions a day, try again in %s",
+		telegramDailyLimit, time.Until(next).Round(time.Minute))
+}
+
+// useTelegramQuota counts a queued conversion, failing if none are left
+func useTelegramQuota(chatID int64) error {
+	if telegramDailyLimit == 0 {
+		return nil
+	}
+	telegramUsage.Lock()
+	defer telegramUsage.Unlock()
+
+	times := telegramQuotaUsageLocked(chatID)
+	if len(times) >= telegramDailyLimit {
+		return fmt.Errorf("you reached the limit of %d conversions a day, try again in %s",
+			telegramDailyLimit, time.Until(times[0].Add(TelegramQuotaWindow)).Round(time.Minute))
+	}
+	telegramUsage.chats[chatID] = append(times, time.Now())
+	return nil
+}
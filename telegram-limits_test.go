@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestCheckDownloadSignature(t *testing.T) {
+	future := time.Now().Add(time.Hour).Unix()
+	past := time.Now().Add(-time.Hour).Unix()
+
+	tests := []struct {
+		name  string
+		query string
+		want  int // 0 when accepted
+	}{
+		{"valid", fmt.Sprintf("expires=%d&sig=%s", future, signDownload([]string{"job-1"}, future)), 0},
+		{"unsigned", "", http.StatusForbidden},
+		{"expiry only", fmt.Sprintf("expires=%d", future), http.StatusForbidden},
+		{"expired", fmt.Sprintf("expires=%d&sig=%s", past, signDownload([]string{"job-1"}, past)), http.StatusGone},
+		{"bad expiry", "expires=soon&sig=abc", http.StatusGone},
+		{"wrong signature", fmt.Sprintf("expires=%d&sig=abc", future), http.StatusForbidden},
+		{"other job", fmt.Sprintf("expires=%d&sig=%s", future, signDownload([]string{"job-2"}, future)), http.StatusForbidden},
+		{"extended expiry", fmt.Sprintf("expires=%d&sig=%s", future+60, signDownload([]string{"job-1"}, future)), http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/api/jobs/job-1/download/signed?"+tt.query, nil)
+		rec := httptest.NewRecorder()
+		ok := checkDownloadSignature(rec, req, "job-1")
+
+		if tt.want == 0 {
+			if !ok {
+				t.Errorf("%s: refused with %d", tt.name, rec.Code)
+			}
+			continue
+		}
+		if ok || rec.Code != tt.want {
+			t.Errorf("%s: ok = %v, status %d; want refused with %d", tt.name, ok, rec.Code, tt.want)
+		}
+	}
+}
+
+func TestSignedJobDownloadURL(t *testing.T) {
+	defer func(previous string) { publicURL = previous }(publicURL)
+
+	publicURL = ""
+	if got := signedJobDownloadURL("job-1"); got != "" {
+		t.Errorf("without PUBLIC_URL got %q, want no link", got)
+	}
+
+	publicURL = "https://convert.example.com"
+	link := signedJobDownloadURL("job-1")
+	req := httptest.NewRequest(http.MethodGet, link, nil)
+	if req.URL.Path != "/api/jobs/job-1/download/signed" {
+		t.Errorf("link path = %q", req.URL.Path)
+	}
+	if !checkDownloadSignature(httptest.NewRecorder(), req, "job-1") {
+		t.Errorf("the link %q does not pass its own check", link)
+	}
+}
+
+func TestTelegramJobDownloadsNeedASignature(t *testing.T) {
+	job := &Job{ID: "tg-download-test", Status: "completed", OutputName: "missing.mp4", Owner: telegramOwner(7)}
+	queue.mu.Lock()
+	queue.completed[job.ID] = job
+	queue.mu.Unlock()
+	defer func() {
+		queue.mu.Lock()
+		delete(queue.completed, job.ID)
+		queue.mu.Unlock()
+	}()
+
+	get := func(handler http.HandlerFunc, target string) int {
+		req := mux.SetURLVars(httptest.NewRequest(http.MethodGet, target, nil), map[string]string{"id": job.ID})
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec.Code
+	}
+
+	if code := get(handleDownload, "/api/jobs/"+job.ID+"/download"); code != http.StatusForbidden {
+		t.Errorf("plain download of a bot job gave %d, want 403", code)
+	}
+
+	expires := time.Now().Add(time.Hour).Unix()
+	signed := fmt.Sprintf("/api/jobs/%s/download/signed?expires=%d&sig=%s", job.ID, expires, signDownload([]string{job.ID}, expires))
+	// The output file does not exist, so passing the check ends in a 404
+	if code := get(handleSignedJobDownload, signed); code != http.StatusNotFound {
+		t.Errorf("signed download gave %d, want 404 from the missing file", code)
+	}
+}
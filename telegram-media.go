@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/google/uuid"
+)
+
+// MaxTelegramLinks is how many video links one message may queue
+const MaxTelegramLinks = 5
+
+// telegramMedia is a video sent to the bot as a document, video, video
+// note or animation
+type telegramMedia struct {
+	Kind     string
+	FileID   string
+	FileName string
+	FileSize int64
+}
+
+// telegramMediaFromMessage returns the video attachment of a message, or nil.
+// Animations also arrive with a Document, so they are checked first.
+func telegramMediaFromMessage(message *tgbotapi.Message) *telegramMedia {
+	switch {
+	case message.Animation != nil:
+		a := message.Animation
+		return &telegramMedia{"animation", a.FileID, mediaFileName(a.FileName, "animation", a.MimeType), int64(a.FileSize)}
+	case message.Video != nil:
+		v := message.Video
+		return &telegramMedia{"video", v.FileID, mediaFileName(v.FileName, "video", v.MimeType), int64(v.FileSize)}
+	case message.VideoNote != nil:
+		n := message.VideoNote
+		return &telegramMedia{"video note", n.FileID, mediaFileName("", "video_note", "video/mp4"), int64(n.FileSize)}
+	case message.Document != nil:
+		d := message.Document
+		return &telegramMedia{"document", d.FileID, mediaFileName(d.FileName, "document", d.MimeType), int64(d.FileSize)}
+	}
+	return nil
+}
+
+// mediaFileName keeps Telegram's name, or makes one up from the MIME type
+func mediaFileName(name, fallback, mimeType string) string {
+	if name = sanitizeFilename(name); name != "" {
+		return name
+	}
+	ext := ".mp4"
+	switch mimeType {
+	case "video/webm":
+		ext = ".webm"
+	case "video/quicktime":
+		ext = ".mov"
+	case "video/x-matroska":
+		ext = ".mkv"
+	}
+	return fallback + "_" + time.Now().Format("20060102_150405") + ext
+}
+
+// messageURLs returns the http(s) links of a message and its text with
+// the links removed, which is parsed like a caption
+func messageURLs(message *tgbotapi.Message) ([]string, string) {
+	text := message.Text
+	entities := message.Entities
+	if text == "" {
+		text = message.Caption
+		entities = message.CaptionEntities
+	}
+
+	// Entity offsets count UTF-16 code units
+	units := utf16.Encode([]rune(text))
+	links := make([]string, 0)
+	remove := make([]bool, len(units))
+
+	for _, entity := range entities {
+		if entity.Offset < 0 || entity.Offset+entity.Length > len(units) {
+			continue
+		}
+		var link string
+		switch entity.Type {
+		case "url":
+			link = string(utf16.Decode(units[entity.Offset : entity.Offset+entity.Length]))
+		case "text_link":
+			link = entity.URL
+		default:
+			continue
+		}
+		// Telegram also marks bare "example.com/clip.webm" as a url
+		if !strings.Contains(link, "://") {
+			link = "https://" + link
+		}
+		if u, err := url.Parse(link); err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			continue
+		}
+
+		links = append(links, link)
+		if entity.Type == "url" {
+			for i := entity.Offset; i < entity.Offset+entity.Length; i++ {
+				remove[i] = true
+			}
+		}
+	}
+
+	rest := make([]uint16, 0, len(units))
+	for i, unit := range units {
+		if !remove[i] {
+			rest = append(rest, unit)
+		}
+	}
+	return links, strings.TrimSpace(string(utf16.Decode(rest)))
+}
+
+// rejectTelegramMessage explains why a message was not converted
+func rejectTelegramMessage(message *tgbotapi.Message) {
+	var text string
+	switch {
+	case len(message.Photo) > 0:
+		text = "❌ That's a photo. Send me a video, a GIF or a link to a video."
+	case message.Audio != nil || message.Voice != nil:
+		text = "❌ Audio-only files can't be converted. Send me a video instead."
+	case message.Sticker != nil:
+		text = "❌ Stickers aren't supported. Send me a video, a GIF or a link to a video."
+	case message.Text != "":
+		text = "🎥 Send me a video (as a file, video, video note or GIF) or paste a link to one. /start shows the options."
+	default:
+		text = "❌ I can only convert videos. Send a video file, a GIF or a link to a video."
+	}
+	telegramBot.Send(tgbotapi.NewMessage(message.Chat.ID, text))
+}
+
+// handleTelegramMedia downloads a video attachment and queues it
+func handleTelegramMedia(message *tgbotapi.Message, media *telegramMedia) {
+	chatID := message.Chat.ID
+
+	if media.FileSize > MaxFileSize {
+		telegramBot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("❌ This %s is too large (max %d MB)", media.Kind, MaxFileSize/(1024*1024))))
+		return
+	}
+	if media.FileSize > telegramDownloadLimit {
+		text := fmt.Sprintf("❌ This %s is %.1f MB, but Telegram only lets bots download files up to %s. Send me a link to it instead",
+			media.Kind, float64(media.FileSize)/(1024*1024), telegramSizeLimitText(telegramDownloadLimit))
+		if publicURL != "" {
+			text += ", or upload it at " + publicURL
+		}
+		telegramBot.Send(tgbotapi.NewMessage(chatID, text))
+		return
+	}
+
+	if err := checkTelegramQuota(chatID, 1); err != nil {
+		telegramBot.Send(tgbotapi.NewMessage(chatID, "❌ "+err.Error()+" (see /limits)"))
+		return
+	}
+
+	options, ok := telegramOptions(chatID, message.Caption)
+	if !ok {
+		return
+	}
+
+	sentMsg, _ := telegramBot.Send(tgbotapi.NewMessage(chatID, "⏳ Processing..."))
+
+	tempPath := filepath.Join(TempDir, fmt.Sprintf("tg_%d_%s_%s", chatID, uuid.New().String()[:8], media.FileName))
+	if err := fetchTelegramFile(media.FileID, tempPath); err != nil {
+		os.Remove(tempPath)
+		editTelegramMessage(chatID, sentMsg.MessageID, "❌ Download failed: "+err.Error())
+		return
+	}
+
+	receiveTelegramFile(chatID, sentMsg.MessageID, media.FileName, tempPath, message.Caption, options)
+}
+
+// handleTelegramLinks downloads each linked video and queues it. The rest
+// of the message is parsed like a caption.
+func handleTelegramLinks(message *tgbotapi.Message, links []string, caption string) {
+	chatID := message.Chat.ID
+
+	if len(links) > MaxTelegramLinks {
+		telegramBot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("❌ Send at most %d links per message", MaxTelegramLinks)))
+		return
+	}
+	if err := checkTelegramQuota(chatID, len(links)); err != nil {
+		telegramBot.Send(tgbotapi.NewMessage(chatID, "❌ "+err.Error()+" (see /limits)"))
+		return
+	}
+
+	options, ok := telegramOptions(chatID, caption)
+	if !ok {
+		return
+	}
+
+	for _, link := range links {
+		u, _ := url.Parse(link)
+		fileName := fileNameFromURL(u)
+		sentMsg, _ := telegramBot.Send(tgbotapi.NewMessage(chatID, "⏳ Downloading "+fileName+"..."))
+
+		tempPath := filepath.Join(TempDir, fmt.Sprintf("tg_%d_%s_%s", chatID, uuid.New().String()[:8], fileName))
+		ctx, cancel := context.WithTimeout(context.Background(), URLDownloadTimeout)
+		_, err := downloadURLToFile(ctx, link, tempPath, MaxFileSize)
+		cancel()
+		if err != nil {
+			os.Remove(tempPath)
+			editTelegramMessage(chatID, sentMsg.MessageID, "❌ Could not download "+link+": "+err.Error())
+			continue
+		}
+
+		receiveTelegramFile(chatID, sentMsg.MessageID, fileName, tempPath, caption, options)
+	}
+}
+
+// telegramOptions parses caption options on top of the chat's defaults,
+// replying with the problem if they are invalid
+func telegramOptions(chatID int64, caption string) (ConversionOptions, bool) {
+	options, err := parseCaptionOptions(caption, defaultsStore.Get(telegramOwner(chatID)))
+	if err != nil {
+		telegramBot.Send(tgbotapi.NewMessage(chatID, "❌ "+err.Error()))
+		return options, false
+	}
+	if options.IsStreaming() {
+		telegramBot.Send(tgbotapi.NewMessage(chatID, "❌ HLS/DASH output is only available through the web API"))
+		return options, false
+	}
+	return options, true
+}
+
+// receiveTelegramFile checks a downloaded file by probing it. Videos sent
+// with options in the caption, or to chats that skip the questions, are
+// queued straight away; otherwise the user picks options first.
+func receiveTelegramFile(chatID int64, msgID int, fileName, tempPath, caption string, options ConversionOptions) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	info, err := probeMedia(ctx, tempPath)
+	cancel()
+	if err != nil {
+		os.Remove(tempPath)
+		editTelegramMessage(chatID, msgID, "❌ "+fileName+" is not a video I can read")
+		return
+	}
+	if info.VideoStream() == nil {
+		os.Remove(tempPath)
+		editTelegramMessage(chatID, msgID, "❌ "+fileName+" has no video stream")
+		return
+	}
+
+	if len(captionValues(caption)) == 0 && !telegramChats.Get(chatID).SkipQuestions {
+		askTelegramChoices(chatID, msgID, fileName, tempPath, info)
+		return
+	}
+	queueTelegramFile(chatID, msgID, fileName, tempPath, options)
+}
+
+// queueTelegramFile moves a checked download into the upload directory and
+// queues it
+func queueTelegramFile(chatID int64, msgID int, fileName, tempPath string, options ConversionOptions) {
+	stat, err := os.Stat(tempPath)
+	if err != nil {
+		editTelegramMessage(chatID, msgID, "❌ "+err.Error())
+		return
+	}
+
+	owner := telegramOwner(chatID)
+	job := &Job{
+		ID:             uuid.New().String(),
+		FileName:       fileName,
+		FileSize:       stat.Size(),
+		OutputName:     telegramOutputName(chatID, fileName, options),
+		Status:         "queued",
+		CreatedAt:      time.Now(),
+		Options:        options,
+		Owner:          owner,
+		TelegramChatID: chatID,
+		TelegramMsgID:  msgID,
+	}
+
+	if err := resolveWatermarkImage(job, owner); err != nil {
+		os.Remove(tempPath)
+		editTelegramMessage(chatID, msgID, "❌ "+err.Error()+" (send a PNG with the caption /watermark)")
+		return
+	}
+
+	// Checked again here: the chat may have queued others while choosing
+	if err := useTelegramQuota(chatID); err != nil {
+		os.Remove(tempPath)
+		editTelegramMessage(chatID, msgID, "❌ "+err.Error()+" (see /limits)")
+		return
+	}
+
+	if err := os.Rename(tempPath, sourcePath(job)); err != nil {
+		os.Remove(tempPath)
+		log.Printf("Telegram job %s: %v", job.ID, err)
+		editTelegramMessage(chatID, msgID, "❌ Could not store the file")
+		return
+	}
+
+	queue.mu.Lock()
+	queue.jobs = append(queue.jobs, job)
+	for i, j := range queue.jobs {
+		j.QueuePos = i + 1
+	}
+	queue.mu.Unlock()
+
+	// The Telegram notifier follows the job from here
+	broadcastUpdate(job)
+}
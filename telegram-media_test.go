@@ -0,0 +1,96 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestMessageURLs(t *testing.T) {
+	tests := []struct {
+		name      string
+		message   *tgbotapi.Message
+		wantLinks []string
+		wantRest  string
+	}{
+		{
+			name: "url entity with options",
+			message: &tgbotapi.Message{
+				Text:     "https://example.com/a.webm 0:10-0:20",
+				Entities: []tgbotapi.MessageEntity{{Type: "url", Offset: 0, Length: 26}},
+			},
+			wantLinks: []string{"https://example.com/a.webm"},
+			wantRest:  "0:10-0:20",
+		},
+		{
+			name: "offsets in UTF-16 after an emoji",
+			message: &tgbotapi.Message{
+				Text:     "🎬 example.com/b.mp4 mute",
+				Entities: []tgbotapi.MessageEntity{{Type: "url", Offset: 3, Length: 17}},
+			},
+			wantLinks: []string{"https://example.com/b.mp4"},
+			wantRest:  "🎬  mute",
+		},
+		{
+			name: "text link in a caption keeps its text",
+			message: &tgbotapi.Message{
+				Caption:         "this clip",
+				CaptionEntities: []tgbotapi.MessageEntity{{Type: "text_link", Offset: 5, Length: 4, URL: "http://example.com/c.webm"}},
+			},
+			wantLinks: []string{"http://example.com/c.webm"},
+			wantRest:  "this clip",
+		},
+		{
+			name: "other schemes and bad offsets are ignored",
+			message: &tgbotapi.Message{
+				Text: "ftp://example.com/d",
+				Entities: []tgbotapi.MessageEntity{
+					{Type: "url", Offset: 0, Length: 19},
+					{Type: "url", Offset: 10, Length: 50},
+				},
+			},
+			wantLinks: []string{},
+			wantRest:  "ftp://example.com/d",
+		},
+	}
+
+	for _, tt := range tests {
+		links, rest := messageURLs(tt.message)
+		if !reflect.DeepEqual(links, tt.wantLinks) || rest != tt.wantRest {
+			t.Errorf("%s: messageURLs() = %q, %q; want %q, %q", tt.name, links, rest, tt.wantLinks, tt.wantRest)
+		}
+	}
+}
+
+func TestTelegramMediaFromMessage(t *testing.T) {
+	animation := &tgbotapi.Message{
+		Animation: &tgbotapi.Animation{FileID: "anim", FileName: "fun.mp4"},
+		Document:  &tgbotapi.Document{FileID: "doc"},
+	}
+	if media := telegramMediaFromMessage(animation); media == nil || media.Kind != "animation" || media.FileID != "anim" {
+		t.Errorf("animation with document = %+v, want the animation", media)
+	}
+
+	note := &tgbotapi.Message{VideoNote: &tgbotapi.VideoNote{FileID: "note", FileSize: 10}}
+	media := telegramMediaFromMessage(note)
+	if media == nil || media.Kind != "video note" || !strings.HasPrefix(media.FileName, "video_note_") || !strings.HasSuffix(media.FileName, ".mp4") {
+		t.Errorf("video note = %+v", media)
+	}
+
+	if media := telegramMediaFromMessage(&tgbotapi.Message{Text: "hi"}); media != nil {
+		t.Errorf("text message = %+v, want nil", media)
+	}
+}
+
+func TestMediaFileName(t *testing.T) {
+	if got := mediaFileName("../clip.webm", "video", "video/webm"); strings.Contains(got, "/") || strings.Contains(got, "..") {
+		t.Errorf("mediaFileName() kept a path: %q", got)
+	}
+	for mime, ext := range map[string]string{"video/webm": ".webm", "video/quicktime": ".mov", "video/x-matroska": ".mkv", "": ".mp4"} {
+		if got := mediaFileName("", "video", mime); !strings.HasPrefix(got, "video_") || !strings.HasSuffix(got, ext) {
+			t.Errorf("mediaFileName(%q) = %q, want video_*%s", mime, got, ext)
+		}
+	}
+}
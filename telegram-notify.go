@@ -0,0 +1,229 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// TelegramEditInterval spaces the status message edits sent to one chat;
+// Telegram throttles bots that edit faster
+const TelegramEditInterval = 3 * time.Second
+
+// maxShownEdits bounds the per-chat cache of message texts on screen
+const maxShownEdits = 100
+
+// runTelegramNotifier follows the job event bus and keeps each Telegram
+// job's status message up to date, sending the result once it completes
+func runTelegramNotifier() {
+	for event := range hub.Listen(wsSendBuffer) {
+		if event.Type == "job_update" && isTelegramOwner(event.Owner) {
+			notifyTelegram(event.JobID)
+		}
+	}
+}
+
+// notifyTelegram reports the job's current state to its chat. Like
+// notifyWebhooks it tracks the last status it saw to act on transitions.
+func notifyTelegram(jobID string) {
+	queue.mu.Lock()
+	job, exists := findJobLocked(jobID)
+	if !exists || job.TelegramChatID == 0 {
+		queue.mu.Unlock()
+		return
+	}
+	previous := job.telegramStatus
+	job.telegramStatus = job.Status
+	status, position, errorText, outputName := job.Status, job.QueuePos, job.Error, job.OutputName
+	var stats ProgressStats
+	if job.Stats != nil {
+		stats = *job.Stats
+	}
+	chatID, msgID := job.TelegramChatID, job.TelegramMsgID
+	queue.mu.Unlock()
+
+	switch status {
+	case "queued":
+		text := fmt.Sprintf("📥 Added to queue #%d", position)
+		if previous == "failed" || previous == "cancelled" {
+			text = fmt.Sprintf("🔁 Retrying, queued #%d", position)
+		}
+		queueTelegramEdit(chatID, msgID, text, nil)
+	case "processing":
+		text := fmt.Sprintf("🔄 Converting... %d%%", int(stats.Percent))
+		if details := formatProgress(stats); details != "" {
+			text += "\n" + details
+		}
+		queueTelegramEdit(chatID, msgID, text, nil)
+	case "completed":
+		if previous != "completed" {
+			go deliverTelegramResult(job, outputName)
+		}
+	case "failed":
+		queueTelegramEdit(chatID, msgID, "❌ Conversion failed: "+errorText, telegramRetryMarkup(jobID))
+	case "cancelled":
+		queueTelegramEdit(chatID, msgID, "🚫 Conversion cancelled", telegramRetryMarkup(jobID))
+	}
+}
+
+// deliverTelegramResult uploads the output, or links it when Telegram
+// would reject the upload
+func deliverTelegramResult(job *Job, outputName string) {
+	outputPath := filepath.Join(OutputDir, job.ID+"_"+outputName)
+	if info, err := os.Stat(outputPath); err == nil && info.Size() > telegramUploadLimit {
+		sendTelegramDownloadLink(job, outputName, info.Size())
+		return
+	}
+	sendTelegramFile(job, outputPath, outputName, assetPath(job, "thumb.jpg"))
+}
+
+func telegramRetryMarkup(jobID string) *tgbotapi.InlineKeyboardMarkup {
+	markup := tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("🔁 Retry", "rt:"+jobID)))
+	return &markup
+}
+
+// handleTelegramRetryCallback retries one of the chat's failed jobs; the
+// notifier reports the new state
+func handleTelegramRetryCallback(query *tgbotapi.CallbackQuery) {
+	jobID := strings.TrimPrefix(query.Data, "rt:")
+
+	queue.mu.RLock()
+	job, exists := findJobLocked(jobID)
+	owned := exists && job.Owner == telegramOwner(query.Message.Chat.ID)
+	queue.mu.RUnlock()
+
+	if !owned {
+		telegramBot.Request(tgbotapi.NewCallback(query.ID, "This job is gone, send the video again"))
+		return
+	}
+	if err := retryJob(job); err != nil {
+		telegramBot.Request(tgbotapi.NewCallbackWithAlert(query.ID, "❌ "+err.Error()))
+		return
+	}
+	telegramBot.Request(tgbotapi.NewCallback(query.ID, "Queued again"))
+}
+
+// telegramEdit is the text, and optional keyboard, a message should show
+type telegramEdit struct {
+	text   string
+	markup *tgbotapi.InlineKeyboardMarkup
+}
+
+func (e telegramEdit) same(other telegramEdit) bool {
+	return e.text == other.text && (e.markup == nil) == (other.markup == nil)
+}
+
+// telegramChatEditor coalesces the edits for one chat: only the latest text
+// per message is sent, at most once per TelegramEditInterval
+type telegramChatEditor struct {
+	pending map[int]telegramEdit
+	shown   map[int]telegramEdit
+	next    time.Time
+	timer   *time.Timer
+}
+
+var telegramEditors = struct {
+	sync.Mutex
+	chats map[int64]*telegramChatEditor
+}{
+	chats: make(map[int64]*telegramChatEditor),
+}
+
+// editTelegramMessage replaces the text of a status message, see queueTelegramEdit
+func editTelegramMessage(chatID int64, msgID int, text string) {
+	queueTelegramEdit(chatID, msgID, text, nil)
+}
+
+// queueTelegramEdit schedules an edit, skipping it if the message already
+// shows the text
+func queueTelegramEdit(chatID int64, msgID int, text string, markup *tgbotapi.InlineKeyboardMarkup) {
+	if telegramBot == nil {
+		return
+	}
+	edit := telegramEdit{text: text, markup: markup}
+
+	telegramEditors.Lock()
+	defer telegramEditors.Unlock()
+
+	editor := telegramEditors.chats[chatID]
+	if editor == nil {
+		editor = &telegramChatEditor{
+			pending: make(map[int]telegramEdit),
+			shown:   make(map[int]telegramEdit),
+		}
+		telegramEditors.chats[chatID] = editor
+	}
+
+	if shown, ok := editor.shown[msgID]; ok && shown.same(edit) {
+		delete(editor.pending, msgID)
+		return
+	}
+	editor.pending[msgID] = edit
+	editor.schedule(chatID)
+}
+
+// schedule arms the flush timer. Caller must hold telegramEditors.
+func (e *telegramChatEditor) schedule(chatID int64) {
+	if e.timer != nil || len(e.pending) == 0 {
+		return
+	}
+	wait := time.Until(e.next)
+	if wait < 0 {
+		wait = 0
+	}
+	e.timer = time.AfterFunc(wait, func() { flushTelegramEdits(chatID) })
+}
+
+// flushTelegramEdits sends the chat's pending edits. Flood control puts an
+// edit back unless a newer one replaced it meanwhile.
+func flushTelegramEdits(chatID int64) {
+	telegramEditors.Lock()
+	editor := telegramEditors.chats[chatID]
+	edits := editor.pending
+	editor.pending = make(map[int]telegramEdit)
+	editor.timer = nil
+	editor.next = time.Now().Add(TelegramEditInterval)
+	if len(editor.shown) > maxShownEdits {
+		editor.shown = make(map[int]telegramEdit)
+	}
+	for msgID, edit := range edits {
+		editor.shown[msgID] = edit
+	}
+	telegramEditors.Unlock()
+
+	for msgID, edit := range edits {
+		config := tgbotapi.NewEditMessageText(chatID, msgID, edit.text)
+		config.ReplyMarkup = edit.markup
+
+		_, err := telegramBot.Send(config)
+		if err == nil || strings.Contains(err.Error(), "message is not modified") {
+			continue
+		}
+
+		var apiErr *tgbotapi.Error
+		telegramEditors.Lock()
+		delete(editor.shown, msgID)
+		if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+			if _, newer := editor.pending[msgID]; !newer {
+				editor.pending[msgID] = edit
+			}
+			editor.next = time.Now().Add(time.Duration(apiErr.RetryAfter) * time.Second)
+			if editor.timer != nil {
+				editor.timer.Stop()
+				editor.timer = nil
+			}
+			editor.schedule(chatID)
+		} else {
+			log.Printf("Telegram chat %d: editing message %d failed: %v", chatID, msgID, err)
+		}
+		telegramEditors.Unlock()
+	}
+}
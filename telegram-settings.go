@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+)
+
+// TelegramChatSettings are bot preferences that are not conversion options;
+// those live in defaultsStore under telegramOwner(chatID)
+type TelegramChatSettings struct {
+	// Queue videos with the chat's defaults instead of asking first
+	SkipQuestions bool `json:"skip_questions,omitempty"`
+	// Output naming: "" keeps the original name, or NamingPrefix or NamingDate
+	Naming string `json:"naming,omitempty"`
+}
+
+// TelegramChatStore keeps per-chat settings, persisted as JSON in DataDir
+type TelegramChatStore struct {
+	mu    sync.RWMutex
+	path  string
+	chats map[int64]TelegramChatSettings
+}
+
+var telegramChats *TelegramChatStore
+
+func NewTelegramChatStore(path string) *TelegramChatStore {
+	store := &TelegramChatStore{
+		path:  path,
+		chats: make(map[int64]TelegramChatSettings),
+	}
+
+	data, err := os.ReadFile(path)
+	if err == nil {
+		if err := json.Unmarshal(data, &store.chats); err != nil {
+			log.Printf("Warning: ignoring corrupt Telegram chats file %s: %v", path, err)
+		}
+	}
+	return store
+}
+
+func (s *TelegramChatStore) Get(chatID int64) TelegramChatSettings {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.chats[chatID]
+}
+
+// Update applies change to the chat's settings and saves the store
+func (s *TelegramChatStore) Update(chatID int64, change func(*TelegramChatSettings)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	settings := s.chats[chatID]
+	change(&settings)
+	if settings == (TelegramChatSettings{}) {
+		delete(s.chats, chatID)
+	} else {
+		s.chats[chatID] = settings
+	}
+	return s.save()
+}
+
+// save writes the store atomically. Caller must hold s.mu.
+func (s *TelegramChatStore) save() error {
+	data, err := json.MarshalIndent(s.chats, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
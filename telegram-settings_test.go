@@ -0,0 +1,38 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTelegramChatStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chats.json")
+	store := NewTelegramChatStore(path)
+
+	if err := store.Update(1, func(s *TelegramChatSettings) { s.SkipQuestions = true }); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Update(2, func(s *TelegramChatSettings) { s.Naming = NamingDate }); err != nil {
+		t.Fatal(err)
+	}
+	// Back to the defaults, so the chat is dropped from the file
+	if err := store.Update(2, func(s *TelegramChatSettings) { s.Naming = "" }); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded := NewTelegramChatStore(path)
+	if got := reloaded.Get(1); !got.SkipQuestions {
+		t.Errorf("chat 1 after reload = %+v", got)
+	}
+	if _, kept := reloaded.chats[2]; kept {
+		t.Error("chat 2 with default settings was saved")
+	}
+
+	if err := os.WriteFile(path, []byte("{not json"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if got := NewTelegramChatStore(path).Get(1); got != (TelegramChatSettings{}) {
+		t.Errorf("corrupt file gave %+v, want defaults", got)
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Telegram send retries and upload progress
+const (
+	TelegramSendAttempts           = 4
+	TelegramMaxRetryAfter          = 5 * time.Minute
+	TelegramUploadProgressInterval = 3 * time.Second
+)
+
+// sendTelegramRetrying sends what build returns, waiting out 429 retry_after
+// and retrying failed connections. build runs per attempt so uploads can
+// reopen their files; the returned closer, if any, is closed after the attempt.
+func sendTelegramRetrying(build func() (tgbotapi.Chattable, io.Closer, error)) (tgbotapi.Message, error) {
+	var lastErr error
+	for attempt := 1; attempt <= TelegramSendAttempts; attempt++ {
+		chattable, closer, err := build()
+		if err != nil {
+			return tgbotapi.Message{}, err
+		}
+
+		msg, err := telegramBot.Send(chattable)
+		if closer != nil {
+			closer.Close()
+		}
+		if err == nil {
+			return msg, nil
+		}
+		lastErr = err
+
+		wait, retry := telegramRetryDelay(err, attempt)
+		if !retry || attempt == TelegramSendAttempts {
+			break
+		}
+		log.Printf("Telegram send failed (attempt %d/%d), retrying in %s: %v", attempt, TelegramSendAttempts, wait, err)
+		time.Sleep(wait)
+	}
+	return tgbotapi.Message{}, lastErr
+}
+
+// telegramRetryDelay reports whether a failed send is worth retrying and
+// when. Flood control says how long to wait; other API errors are final.
+// Of network errors only failed connections are retried: once the request
+// was written, Telegram may have posted the file even if the reply was lost.
+func telegramRetryDelay(err error, attempt int) (time.Duration, bool) {
+	var apiErr *tgbotapi.Error
+	if errors.As(err, &apiErr) {
+		if apiErr.RetryAfter <= 0 {
+			return 0, false
+		}
+		wait := time.Duration(apiErr.RetryAfter) * time.Second
+		if wait > TelegramMaxRetryAfter {
+			return 0, false
+		}
+		return wait, true
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		// Nothing was sent: back off 2s, 4s, 6s
+		return time.Duration(attempt) * 2 * time.Second, true
+	}
+	return 0, false
+}
+
+// uploadProgressReader counts bytes handed to the multipart writer and
+// reports them at most once per TelegramUploadProgressInterval
+type uploadProgressReader struct {
+	reader io.Reader
+	total  int64
+	sent   int64
+	last   time.Time
+	report func(sent, total int64)
+}
+
+func (r *uploadProgressReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.sent += int64(n)
+	if now := time.Now(); now.Sub(r.last) >= TelegramUploadProgressInterval && r.sent < r.total {
+		r.last = now
+		r.report(r.sent, r.total)
+	}
+	return n, err
+}
+
+// sendTelegramFile uploads a finished job's output from disk, showing upload
+// progress in the status message. Failures are recorded on the job.
+func sendTelegramFile(job *Job, outputPath, outputName, thumbPath string) {
+	chatID, msgID := job.TelegramChatID, job.TelegramMsgID
+
+	info, err := os.Stat(outputPath)
+	if err != nil {
+		recordTelegramSendError(job, err)
+		editTelegramMessage(chatID, msgID, "❌ Failed to send file: the output is gone")
+		return
+	}
+	size := info.Size()
+
+	// Edits are queued per chat, so reporting never stalls the upload
+	report := func(sent, total int64) {
+		editTelegramMessage(chatID, msgID, fmt.Sprintf("📤 Uploading... %d%% (%.1f of %.1f MB)",
+			sent*100/total, float64(sent)/(1024*1024), float64(total)/(1024*1024)))
+	}
+
+	editTelegramMessage(chatID, msgID, "📤 Uploading...")
+
+	_, err = sendTelegramRetrying(func() (tgbotapi.Chattable, io.Closer, error) {
+		file, err := os.Open(outputPath)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		doc := tgbotapi.NewDocument(chatID, tgbotapi.FileReader{
+			Name:   outputName,
+			Reader: &uploadProgressReader{reader: file, total: size, last: time.Now(), report: report},
+		})
+		doc.Caption = "✅ Converted successfully!"
+		if fileExists(thumbPath) {
+			doc.Thumb = tgbotapi.FilePath(thumbPath)
+		}
+		return doc, file, nil
+	})
+
+	if err != nil {
+		recordTelegramSendError(job, err)
+		// The upload limit of a Bot API server may be lower than we assumed
+		if strings.Contains(err.Error(), "Too Large") {
+			sendTelegramDownloadLink(job, outputName, size)
+			return
+		}
+		editTelegramMessage(chatID, msgID, "❌ Failed to send file: "+err.Error())
+		return
+	}
+
+	editTelegramMessage(chatID, msgID, fmt.Sprintf("✅ Done! File: %s", outputName))
+}
+
+// recordTelegramSendError keeps the failure on the job for the API and UI
+func recordTelegramSendError(job *Job, err error) {
+	log.Printf("Job %s: sending to Telegram chat %d failed: %v", job.ID, job.TelegramChatID, err)
+
+	queue.mu.Lock()
+	job.TelegramError = err.Error()
+	queue.mu.Unlock()
+
+	broadcastUpdate(job)
+}
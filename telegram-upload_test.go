@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestTelegramRetryDelay(t *testing.T) {
+	flood := func(seconds int) error {
+		return &tgbotapi.Error{Code: 429, Message: "Too Many Requests", ResponseParameters: tgbotapi.ResponseParameters{RetryAfter: seconds}}
+	}
+
+	tests := []struct {
+		name      string
+		err       error
+		attempt   int
+		wantWait  time.Duration
+		wantRetry bool
+	}{
+		{"flood control", flood(7), 1, 7 * time.Second, true},
+		{"wrapped flood control", fmt.Errorf("send: %w", flood(2)), 3, 2 * time.Second, true},
+		{"flood wait too long", flood(int(TelegramMaxRetryAfter/time.Second) + 1), 1, 0, false},
+		{"bad request", &tgbotapi.Error{Code: 400, Message: "Bad Request"}, 1, 0, false},
+		{"connection refused", &url.Error{Op: "Post", Err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}}, 1, 2 * time.Second, true},
+		{"connection refused again", &net.OpError{Op: "dial", Err: errors.New("no route to host")}, 3, 6 * time.Second, true},
+		{"timeout after sending", &url.Error{Op: "Post", Err: &net.OpError{Op: "read", Err: errors.New("i/o timeout")}}, 1, 0, false},
+		{"unknown error", errors.New("connection reset"), 1, 0, false},
+	}
+
+	for _, tt := range tests {
+		wait, retry := telegramRetryDelay(tt.err, tt.attempt)
+		if wait != tt.wantWait || retry != tt.wantRetry {
+			t.Errorf("%s: telegramRetryDelay() = %v, %v; want %v, %v", tt.name, wait, retry, tt.wantWait, tt.wantRetry)
+		}
+	}
+}
+
+func TestUploadProgressReader(t *testing.T) {
+	reports := make([]int64, 0)
+	reader := &uploadProgressReader{
+		reader: iotest.OneByteReader(strings.NewReader("abcdef")),
+		total:  6,
+		report: func(sent, total int64) { reports = append(reports, sent) },
+	}
+
+	data, err := io.ReadAll(reader)
+	if err != nil || string(data) != "abcdef" {
+		t.Fatalf("ReadAll() = %q, %v", data, err)
+	}
+	if reader.sent != 6 {
+		t.Errorf("sent = %d, want 6", reader.sent)
+	}
+	// The first read reports, later ones fall inside the interval
+	if len(reports) != 1 || reports[0] != 1 {
+		t.Errorf("reports = %v, want [1]", reports)
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Preview generation settings
+const (
+	PosterWidth       = 640
+	TelegramThumbSize = 320 // Telegram rejects thumbnails above 320px
+	SpriteTileWidth   = 160
+	SpriteTileHeight  = 90
+	SpriteColumns     = 10
+	SpriteMaxTiles    = 100
+	AnimatedSeconds   = 3
+)
+
+// PreviewAssets lists the preview URLs available for a completed job
+type PreviewAssets struct {
+	Poster     string `json:"poster,omitempty"`
+	PosterWebP string `json:"poster_webp,omitempty"`
+	Sprite     string `json:"sprite,omitempty"`
+	SpriteVTT  string `json:"sprite_vtt,omitempty"`
+	Animated   string `json:"animated,omitempty"`
+}
+
+// assetsDir holds everything generated for a job besides the main output
+func assetsDir(job *Job) string {
+	return filepath.Join(OutputDir, job.ID+"_assets")
+}
+
+func assetPath(job *Job, name string) string {
+	return filepath.Join(assetsDir(job), name)
+}
+
+func ensureAssetsDir(job *Job) error {
+	if err := os.MkdirAll(assetsDir(job), 0755); err != nil {
+		return fmt.Errorf("cannot create assets dir: %v", err)
+	}
+	return nil
+}
+
+// generatePreviews builds the poster, sprite sheet and (optionally) the
+// animated preview from the converted output. Each asset is best effort;
+// a failure is logged and the asset is left out.
+func generatePreviews(ctx context.Context, job *Job, output string, duration float64) *PreviewAssets {
+	if err := ensureAssetsDir(job); err != nil {
+		log.Printf("Job %s: %v", job.ID, err)
+		return nil
+	}
+
+	base := "/api/jobs/" + job.ID
+	assets := &PreviewAssets{}
+
+	if err := generatePoster(ctx, job, output, duration); err != nil {
+		log.Printf("Job %s: poster generation failed: %v", job.ID, err)
+	} else {
+		assets.Poster = base + "/thumbnail"
+		if fileExists(assetPath(job, "poster.webp")) {
+			assets.PosterWebP = base + "/thumbnail?format=webp"
+		}
+	}
+
+	if duration > 0 {
+		if err := generateSprite(ctx, job, output, duration); err != nil {
+			log.Printf("Job %s: sprite generation failed: %v", job.ID, err)
+		} else {
+			assets.Sprite = base + "/sprite"
+			assets.SpriteVTT = base + "/sprite.vtt"
+		}
+	}
+
+	if job.Options.AnimatedPreview {
+		if err := generateAnimatedPreview(ctx, job, output, duration); err != nil {
+			log.Printf("Job %s: animated preview failed: %v", job.ID, err)
+		} else {
+			assets.Animated = base + "/preview"
+		}
+	}
+
+	return assets
+}
+
+// generatePoster picks a representative, non-black frame
+func generatePoster(ctx context.Context, job *Job, output string, duration float64) error {
+	poster := assetPath(job, "poster.jpg")
+	seek := formatSeconds(duration * 0.1)
+
+	// blackframe with amount=0 tags every frame with its black percentage,
+	// metadata drops the mostly black ones and thumbnail picks the most
+	// representative frame of what is left
+	smart := fmt.Sprintf("blackframe=amount=0:threshold=32,"+
+		"metadata=select:key=lavfi.blackframe.pblack:value=90:function=less,"+
+		"thumbnail=50,scale=%d:-2", PosterWidth)
+
+	err := runFFmpegQuiet(ctx, "-ss", seek, "-i", output, "-vf", smart, "-frames:v", "1", "-q:v", "3", "-y", poster)
+	if err != nil || !fileExists(poster) {
+		// Entirely black or very short input: take the first frame
+		err = runFFmpegQuiet(ctx, "-i", output, "-vf", fmt.Sprintf("scale=%d:-2", PosterWidth),
+			"-frames:v", "1", "-q:v", "3", "-y", poster)
+		if err != nil {
+			return err
+		}
+	}
+
+	thumb := assetPath(job, "thumb.jpg")
+	scale := fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=decrease", TelegramThumbSize, TelegramThumbSize)
+	if err := runFFmpegQuiet(ctx, "-i", poster, "-vf", scale, "-q:v", "5", "-y", thumb); err != nil {
+		log.Printf("Job %s: telegram thumbnail failed: %v", job.ID, err)
+	}
+
+	if err := runFFmpegQuiet(ctx, "-i", poster, "-quality", "80", "-y", assetPath(job, "poster.webp")); err != nil {
+		log.Printf("Job %s: webp poster failed: %v", job.ID, err)
+	}
+
+	return nil
+}
+
+// generateSprite writes a tiled sprite sheet and a WebVTT file mapping
+// time ranges to tiles, for hover scrubbing
+func generateSprite(ctx context.Context, job *Job, output string, duration float64) error {
+	interval := math.Max(1, math.Ceil(duration/SpriteMaxTiles))
+	tiles := int(math.Ceil(duration / interval))
+	rows := (tiles + SpriteColumns - 1) / SpriteColumns
+
+	filter := fmt.Sprintf("fps=1/%g,scale=%d:%d:force_original_aspect_ratio=decrease,pad=%d:%d:(ow-iw)/2:(oh-ih)/2,tile=%dx%d",
+		interval, SpriteTileWidth, SpriteTileHeight, SpriteTileWidth, SpriteTileHeight, SpriteColumns, rows)
+
+	sprite := assetPath(job, "sprite.jpg")
+	if err := runFFmpegQuiet(ctx, "-i", output, "-vf", filter, "-frames:v", "1", "-q:v", "5", "-y", sprite); err != nil {
+		return err
+	}
+
+	var vtt strings.Builder
+	vtt.WriteString("WEBVTT\n\n")
+	for i := 0; i < tiles; i++ {
+		start := float64(i) * interval
+		end := math.Min(start+interval, duration)
+		x := (i % SpriteColumns) * SpriteTileWidth
+		y := (i / SpriteColumns) * SpriteTileHeight
+		fmt.Fprintf(&vtt, "%s --> %s\n/api/jobs/%s/sprite#xywh=%d,%d,%d,%d\n\n",
+			vttTimestamp(start), vttTimestamp(end), job.ID, x, y, SpriteTileWidth, SpriteTileHeight)
+	}
+
+	return os.WriteFile(assetPath(job, "sprite.vtt"), []byte(vtt.String()), 0644)
+}
+
+// generateAnimatedPreview writes a short looping WebP from the middle of the video
+func generateAnimatedPreview(ctx context.Context, job *Job, output string, duration float64) error {
+	start := 0.0
+	if duration > AnimatedSeconds {
+		start = (duration - AnimatedSeconds) / 2
+	}
+
+	return runFFmpegQuiet(ctx,
+		"-ss", formatSeconds(start),
+		"-t", fmt.Sprint(AnimatedSeconds),
+		"-i", output,
+		"-vf", "fps=10,scale=320:-2",
+		"-loop", "0",
+		"-an",
+		"-y", assetPath(job, "preview.webp"))
+}
+
+func runFFmpegQuiet(ctx context.Context, args ...string) error {
+	args = append([]string{"-v", "error"}, args...)
+	output, err := exec.CommandContext(ctx, "ffmpeg", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, lastStderrLine(string(output)))
+	}
+	return nil
+}
+
+func vttTimestamp(seconds float64) string {
+	ms := int(math.Round(seconds * 1000))
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, (ms/60000)%60, (ms/1000)%60, ms%1000)
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// Preview handlers
+
+func handleThumbnail(w http.ResponseWriter, r *http.Request) {
+	name, contentType := "poster.jpg", "image/jpeg"
+	if r.URL.Query().Get("format") == "webp" {
+		name, contentType = "poster.webp", "image/webp"
+	}
+	serveJobAsset(w, r, name, contentType)
+}
+
+func handlePreview(w http.ResponseWriter, r *http.Request) {
+	serveJobAsset(w, r, "preview.webp", "image/webp")
+}
+
+func handleSprite(w http.ResponseWriter, r *http.Request) {
+	serveJobAsset(w, r, "sprite.jpg", "image/jpeg")
+}
+
+func handleSpriteVTT(w http.ResponseWriter, r *http.Request) {
+	serveJobAsset(w, r, "sprite.vtt", "text/vtt")
+}
+
+func serveJobAsset(w http.ResponseWriter, r *http.Request, name, contentType string) {
+	jobID := mux.Vars(r)["id"]
+
+	queue.mu.RLock()
+	job, exists := queue.completed[jobID]
+	queue.mu.RUnlock()
+
+	if !exists {
+		http.Error(w, "Job not found or not completed", http.StatusNotFound)
+		return
+	}
+
+	path := assetPath(job, name)
+	if !fileExists(path) {
+		http.Error(w, "Preview not available", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	http.ServeFile(w, r, path)
+}
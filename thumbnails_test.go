@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+func TestVTTTimestamp(t *testing.T) {
+	tests := []struct {
+		seconds float64
+		want    string
+	}{
+		{0, "00:00:00.000"},
+		{1.5, "00:00:01.500"},
+		{61.0004, "00:01:01.000"},
+		{59.9996, "00:01:00.000"},
+		{3723.25, "01:02:03.250"},
+	}
+
+	for _, tt := range tests {
+		if got := vttTimestamp(tt.seconds); got != tt.want {
+			t.Errorf("vttTimestamp(%v) = %q, want %q", tt.seconds, got, tt.want)
+		}
+	}
+}
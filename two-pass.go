@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Target size mode limits
+const (
+	MinTargetSizeMB    = 1
+	MinVideoBitrate    = 150 // kbps; anything lower is unwatchable
+	MuxOverheadPercent = 3   // container overhead reserved from the budget
+)
+
+// BitratePlan is the bitrate split computed for a target size job
+type BitratePlan struct {
+	VideoKbps int `json:"video_kbps"`
+	AudioKbps int `json:"audio_kbps"`
+}
+
+var twoPassChain = []ConversionStrategy{
+	{
+		Name:        "twopass",
+		Description: "two-pass encode",
+		OutputArgs: []string{
+			"-c:v", "libx264",
+			"-preset", "veryfast",
+		},
+		Run: runTwoPass,
+	},
+	{
+		Name:        "twopass-software",
+		Description: "two-pass software-only, error tolerant",
+		InputArgs:   conversionStrategies["software"].InputArgs,
+		OutputArgs: []string{
+			"-c:v", "libx264",
+			"-preset", "veryfast",
+			"-pix_fmt", "yuv420p",
+		},
+		Run: runTwoPass,
+	},
+}
+
+// parseTargetSize accepts "8", "8MB" or "8.5mb"
+func parseTargetSize(value string) (float64, error) {
+	value = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(value)), "mb")
+	size, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || size < MinTargetSizeMB || size > MaxFileSize/(1024*1024) {
+		return 0, fmt.Errorf("target size must be between %d and %d MB", MinTargetSizeMB, MaxFileSize/(1024*1024))
+	}
+	return size, nil
+}
+
+// planTargetBitrate splits the size budget between audio and video, failing
+// if the result would be below MinVideoBitrate
+func planTargetBitrate(sizeMB, duration float64) (*BitratePlan, error) {
+	if duration <= 0 {
+		return nil, fmt.Errorf("cannot use target size mode: the video duration could not be determined")
+	}
+
+	budgetKbps := sizeMB * 1024 * 1024 * 8 / 1000 / duration
+	budgetKbps = budgetKbps * (100 - MuxOverheadPercent) / 100
+
+	audioKbps := 128
+	if budgetKbps < 1000 {
+		audioKbps = 64
+	}
+
+	videoKbps := int(budgetKbps) - audioKbps
+	if videoKbps < MinVideoBitrate {
+		minSize := float64(MinVideoBitrate+audioKbps) * 1000 / 8 * duration / (1024 * 1024) * 100 / (100 - MuxOverheadPercent)
+		return nil, fmt.Errorf("%.1f MB is too small for a %s clip; it would need at least %.1f MB for watchable quality (or trim the video)",
+			sizeMB, formatTimestamp(duration), minSize)
+	}
+
+	return &BitratePlan{VideoKbps: videoKbps, AudioKbps: audioKbps}, nil
+}
+
+// runTwoPass runs both libx264 passes, reporting them as one 0-100 range
+func runTwoPass(ctx context.Context, strategy ConversionStrategy, job *Job, input, output string, duration float64, progress ProgressFunc) error {
+	if job.Bitrate == nil {
+		return fmt.Errorf("two-pass encode without a bitrate plan")
+	}
+
+	passlog := filepath.Join(TempDir, job.ID+"_2pass")
+	defer removePassLogs(passlog)
+
+	bitrate := fmt.Sprintf("%dk", job.Bitrate.VideoKbps)
+	bufsize := fmt.Sprintf("%dk", job.Bitrate.VideoKbps*2)
+
+	for pass := 1; pass <= 2; pass++ {
+		args := jobInputArgs(strategy, job, input)
+		args = append(args, strategy.OutputArgs...)
+		args = append(args, jobOutputArgs(job)...)
+		args = append(args,
+			"-b:v", bitrate,
+			"-maxrate", bitrate,
+			"-bufsize", bufsize,
+			"-pass", strconv.Itoa(pass),
+			"-passlogfile", passlog)
+
+		if pass == 1 {
+			args = append(args, "-an", "-sn", "-f", "null", "-y", os.DevNull)
+		} else {
+			args = append(args,
+				"-c:a", "aac",
+				"-b:a", fmt.Sprintf("%dk", job.Bitrate.AudioKbps))
+			args = append(args, containerArgs(job.Options)...)
+			args = append(args,
+				"-max_muxing_queue_size", "9999",
+				"-y", output)
+		}
+
+		offset := float64(pass-1) * 50
+		firstPass := pass == 1
+		err := convertVideoWithProgress(ctx, args, duration, func(stats ProgressStats) {
+			stats.Percent = offset + stats.Percent/2
+			// The second pass takes about as long again
+			if firstPass && stats.Speed > 0 {
+				stats.ETASeconds += duration / stats.Speed
+			}
+			progress(stats)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func removePassLogs(passlog string) {
+	matches, _ := filepath.Glob(passlog + "*")
+	for _, match := range matches {
+		os.Remove(match)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import "testing"
+
+func TestPlanTargetBitrate(t *testing.T) {
+	tests := []struct {
+		name     string
+		sizeMB   float64
+		duration float64
+		want     *BitratePlan
+	}{
+		{"one minute in 8 MB", 8, 60, &BitratePlan{VideoKbps: 956, AudioKbps: 128}},
+		{"one minute in 50 MB", 50, 60, &BitratePlan{VideoKbps: 6652, AudioKbps: 128}},
+		{"low budget drops audio to 64k", 2, 30, &BitratePlan{VideoKbps: 478, AudioKbps: 64}},
+		{"too small", 10, 600, nil},
+		{"unknown duration", 8, 0, nil},
+	}
+
+	for _, tt := range tests {
+		got, err := planTargetBitrate(tt.sizeMB, tt.duration)
+		if tt.want == nil {
+			if err == nil {
+				t.Errorf("%s: planTargetBitrate() = %+v, want an error", tt.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: planTargetBitrate() error = %v", tt.name, err)
+			continue
+		}
+		if *got != *tt.want {
+			t.Errorf("%s: planTargetBitrate() = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseTargetSize(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    float64
+		wantErr bool
+	}{
+		{"8", 8, false},
+		{"8MB", 8, false},
+		{" 8.5mb ", 8.5, false},
+		{"0", 0, true},
+		{"100000", 0, true},
+		{"eight", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseTargetSize(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseTargetSize(%q) = %v, %v; want %v, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Limits for user supplied transform values
+const (
+	MinDimension = 16
+	MaxDimension = 7680
+)
+
+// CropRect is a crop rectangle in source pixels
+type CropRect struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+	X      int `json:"x"`
+	Y      int `json:"y"`
+}
+
+func (c CropRect) String() string {
+	return fmt.Sprintf("%d:%d:%d:%d", c.Width, c.Height, c.X, c.Y)
+}
+
+// AspectRatio is a target display aspect such as 16:9
+type AspectRatio struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+func (a AspectRatio) String() string {
+	return fmt.Sprintf("%d:%d", a.Width, a.Height)
+}
+
+var cropdetectPattern = regexp.MustCompile(`crop=(\d+):(\d+):(\d+):(\d+)`)
+
+// parseCropRect parses "w:h:x:y"
+func parseCropRect(value string) (*CropRect, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) != 4 {
+		return nil, fmt.Errorf("crop must be width:height:x:y")
+	}
+
+	nums := make([]int, 4)
+	for i, part := range parts {
+		n, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("crop must be width:height:x:y")
+		}
+		nums[i] = n
+	}
+
+	crop := &CropRect{Width: nums[0], Height: nums[1], X: nums[2], Y: nums[3]}
+	if crop.Width < MinDimension || crop.Height < MinDimension ||
+		crop.Width > MaxDimension || crop.Height > MaxDimension {
+		return nil, fmt.Errorf("crop size must be between %d and %d pixels", MinDimension, MaxDimension)
+	}
+	return crop, nil
+}
+
+// parseAspectRatio parses "16:9" or "16/9"
+func parseAspectRatio(value string) (*AspectRatio, error) {
+	value = strings.ReplaceAll(value, "/", ":")
+	w, h, ok := strings.Cut(value, ":")
+	if !ok {
+		return nil, fmt.Errorf("aspect ratio must look like 16:9")
+	}
+
+	width, errW := strconv.Atoi(strings.TrimSpace(w))
+	height, errH := strconv.Atoi(strings.TrimSpace(h))
+	if errW != nil || errH != nil || width <= 0 || height <= 0 || width > 100 || height > 100 {
+		return nil, fmt.Errorf("aspect ratio must look like 16:9")
+	}
+	return &AspectRatio{Width: width, Height: height}, nil
+}
+
+func parseDimension(name, value string) (int, error) {
+	n, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || n < MinDimension || n > MaxDimension {
+		return 0, fmt.Errorf("%s must be between %d and %d", name, MinDimension, MaxDimension)
+	}
+	return n, nil
+}
+
+// HasVideoFilters reports whether the job needs a video filter graph
+func (o ConversionOptions) HasVideoFilters() bool {
+	return o.MaxWidth > 0 || o.MaxHeight > 0 || o.Crop != nil || o.AutoCrop ||
+		o.Rotate != 0 || o.Flip != "" || o.PadAspect != nil || o.Subtitles == SubtitlesBurn ||
+		o.HasWatermark()
+}
+
+// videoFilterChains splits the transform options into the filters that run
+// before the watermark and those that run after it. Order matters: crop
+// works on source pixels, rotation happens before scaling so
+// max_width/max_height apply to the final orientation, subtitles are drawn
+// at output resolution, the watermark sits on the picture rather than on
+// the bars, and padding comes last.
+func (o ConversionOptions) videoFilterChains(burnSubtitles string) (pre, post []string) {
+	pre = make([]string, 0)
+	post = make([]string, 0)
+
+	if o.Crop != nil {
+		pre = append(pre, "crop="+o.Crop.String())
+	}
+
+	switch o.Rotate {
+	case 90:
+		pre = append(pre, "transpose=clock")
+	case 180:
+		pre = append(pre, "hflip", "vflip")
+	case 270:
+		pre = append(pre, "transpose=cclock")
+	}
+
+	if strings.Contains(o.Flip, "h") {
+		pre = append(pre, "hflip")
+	}
+	if strings.Contains(o.Flip, "v") {
+		pre = append(pre, "vflip")
+	}
+
+	if o.MaxWidth > 0 || o.MaxHeight > 0 {
+		w, h := "iw", "ih"
+		if o.MaxWidth > 0 {
+			w = fmt.Sprintf("'min(iw,%d)'", o.MaxWidth)
+		}
+		if o.MaxHeight > 0 {
+			h = fmt.Sprintf("'min(ih,%d)'", o.MaxHeight)
+		}
+		pre = append(pre, fmt.Sprintf("scale=%s:%s:force_original_aspect_ratio=decrease", w, h))
+	}
+
+	if burnSubtitles != "" {
+		pre = append(pre, subtitleBurnFilter(burnSubtitles, o))
+	}
+
+	if o.WatermarkText != "" {
+		post = append(post, o.watermarkTextFilter())
+	}
+
+	if o.PadAspect != nil {
+		a, b := o.PadAspect.Width, o.PadAspect.Height
+		post = append(post, fmt.Sprintf(
+			"pad='max(iw,ih*%d/%d)':'max(ih,iw*%d/%d)':(ow-iw)/2:(oh-ih)/2:black", a, b, b, a))
+	}
+
+	if len(pre) > 0 || len(post) > 0 || o.WatermarkImage {
+		// libx264 with yuv420p needs even dimensions
+		post = append(post, "scale=trunc(iw/2)*2:trunc(ih/2)*2", "setsar=1")
+	}
+	return pre, post
+}
+
+// videoFilterArgs returns the filter options for the job and the output
+// label to map as the video stream. A simple -vf chain is used unless a
+// logo overlay needs a second input, which requires -filter_complex.
+func videoFilterArgs(job *Job) ([]string, string) {
+	if job.WatermarkPath != "" && job.Options.WatermarkImage {
+		return []string{"-filter_complex", videoFilterComplex(job)}, "[vout]"
+	}
+
+	pre, post := job.Options.videoFilterChains(job.SubtitleBurnPath)
+	filters := append(pre, post...)
+	if len(filters) == 0 {
+		return nil, "0:v:0"
+	}
+	return []string{"-vf", strings.Join(filters, ",")}, "0:v:0"
+}
+
+// videoFilterComplex returns the job's video filters as a -filter_complex
+// graph whose output is labelled [vout]
+func videoFilterComplex(job *Job) string {
+	pre, post := job.Options.videoFilterChains(job.SubtitleBurnPath)
+
+	if job.WatermarkPath == "" || !job.Options.WatermarkImage {
+		filters := append(pre, post...)
+		if len(filters) == 0 {
+			filters = []string{"null"}
+		}
+		return "[0:v:0]" + strings.Join(filters, ",") + "[vout]"
+	}
+
+	base := "null"
+	if len(pre) > 0 {
+		base = strings.Join(pre, ",")
+	}
+	return "[0:v:0]" + base + "[base];" +
+		job.Options.watermarkImageGraph(watermarkInputIndex(job)) + "," +
+		strings.Join(post, ",") + "[vout]"
+}
+
+// detectCrop samples the input with cropdetect and returns the black-bar
+// free rectangle, or nil if nothing needs cropping
+func detectCrop(ctx context.Context, input string, opts ConversionOptions, duration float64) (*CropRect, error) {
+	// Sample from the middle of the segment being converted
+	start := opts.TrimStart
+	if clip := opts.ClipDuration(duration); clip > 20 {
+		start += clip/2 - 5
+	}
+
+	cmd := exec.CommandContext(ctx, "ffmpeg",
+		"-ss", formatSeconds(start),
+		"-i", input,
+		"-t", "10",
+		"-an",
+		"-vf", "cropdetect=limit=24:round=2:reset=0",
+		"-f", "null", "-")
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("crop detection failed: %v", err)
+	}
+
+	matches := cropdetectPattern.FindAllStringSubmatch(string(output), -1)
+	if len(matches) == 0 {
+		return nil, nil
+	}
+
+	last := matches[len(matches)-1]
+	crop := &CropRect{}
+	crop.Width, _ = strconv.Atoi(last[1])
+	crop.Height, _ = strconv.Atoi(last[2])
+	crop.X, _ = strconv.Atoi(last[3])
+	crop.Y, _ = strconv.Atoi(last[4])
+
+	if crop.Width < MinDimension || crop.Height < MinDimension {
+		return nil, nil
+	}
+	return crop, nil
+}
+
+// resolveAutoCrop fills in the job's crop rectangle from cropdetect. A failed
+// detection is not fatal; the job is converted uncropped instead.
+func resolveAutoCrop(ctx context.Context, job *Job, input string, duration float64) {
+	if !job.Options.AutoCrop || job.Options.Crop != nil {
+		return
+	}
+
+	crop, err := detectCrop(ctx, input, job.Options, duration)
+	if err != nil {
+		log.Printf("Job %s: %v", job.ID, err)
+		return
+	}
+	if crop == nil {
+		return
+	}
+
+	queue.mu.Lock()
+	job.Options.Crop = crop
+	queue.mu.Unlock()
+	log.Printf("Job %s: detected crop %s", job.ID, crop)
+}
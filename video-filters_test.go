@@ -0,0 +1,91 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseCropRect(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    *CropRect
+		wantErr bool
+	}{
+		{"640:360:0:60", &CropRect{Width: 640, Height: 360, X: 0, Y: 60}, false},
+		{" 100 : 200 : 3 : 4 ", &CropRect{Width: 100, Height: 200, X: 3, Y: 4}, false},
+		{"16:16:0:0", &CropRect{Width: 16, Height: 16}, false},
+		{"640:360:0", nil, true},
+		{"640:360:0:0:0", nil, true},
+		{"640:360:-1:0", nil, true},
+		{"a:360:0:0", nil, true},
+		{"15:360:0:0", nil, true},
+		{"7681:360:0:0", nil, true},
+		{"", nil, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseCropRect(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseCropRect(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseCropRect(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestVideoFilterArgs(t *testing.T) {
+	const even = "scale=trunc(iw/2)*2:trunc(ih/2)*2,setsar=1"
+
+	tests := []struct {
+		name string
+		opts ConversionOptions
+		want string // the -vf value, empty for none
+	}{
+		{"none", ConversionOptions{}, ""},
+		{"crop", ConversionOptions{Crop: &CropRect{Width: 640, Height: 360, Y: 60}}, "crop=640:360:0:60," + even},
+		{"rotate 90", ConversionOptions{Rotate: 90}, "transpose=clock," + even},
+		{"rotate 180", ConversionOptions{Rotate: 180}, "hflip,vflip," + even},
+		{"rotate 270", ConversionOptions{Rotate: 270}, "transpose=cclock," + even},
+		{"flip both", ConversionOptions{Flip: "hv"}, "hflip,vflip," + even},
+		{"max width", ConversionOptions{MaxWidth: 1280},
+			"scale='min(iw,1280)':ih:force_original_aspect_ratio=decrease," + even},
+		{"max both", ConversionOptions{MaxWidth: 1280, MaxHeight: 720},
+			"scale='min(iw,1280)':'min(ih,720)':force_original_aspect_ratio=decrease," + even},
+		{"pad", ConversionOptions{PadAspect: &AspectRatio{Width: 16, Height: 9}},
+			"pad='max(iw,ih*16/9)':'max(ih,iw*9/16)':(ow-iw)/2:(oh-ih)/2:black," + even},
+		{"crop, rotate, scale order", ConversionOptions{
+			Crop: &CropRect{Width: 100, Height: 100}, Rotate: 90, MaxHeight: 480},
+			"crop=100:100:0:0,transpose=clock,scale=iw:'min(ih,480)':force_original_aspect_ratio=decrease," + even},
+	}
+
+	for _, tt := range tests {
+		args, label := videoFilterArgs(&Job{Options: tt.opts})
+		if label != "0:v:0" {
+			t.Errorf("%s: label = %q, want 0:v:0", tt.name, label)
+		}
+		got := ""
+		if len(args) > 0 {
+			if args[0] != "-vf" || len(args) != 2 {
+				t.Errorf("%s: args = %q, want -vf <chain>", tt.name, args)
+				continue
+			}
+			got = args[1]
+		}
+		if got != tt.want {
+			t.Errorf("%s: filters = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestVideoFilterComplexWithoutOverlay(t *testing.T) {
+	got := videoFilterComplex(&Job{Options: ConversionOptions{Rotate: 180}})
+	if !strings.HasPrefix(got, "[0:v:0]hflip,vflip,") || !strings.HasSuffix(got, "[vout]") {
+		t.Errorf("videoFilterComplex() = %q", got)
+	}
+	if got := videoFilterComplex(&Job{}); got != "[0:v:0]null[vout]" {
+		t.Errorf("videoFilterComplex() without filters = %q", got)
+	}
+}
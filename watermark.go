@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Watermark limits and defaults
+const (
+	MaxWatermarkSize       = 5 * 1024 * 1024 // 5MB
+	MaxWatermarkDimension  = 4096
+	MaxWatermarkTextLength = 100
+	WatermarkMargin        = 16
+	DefaultWatermarkAlpha  = 0.8
+	DefaultWatermarkScale  = 0.15 // logo width as a fraction of the video width
+)
+
+// Watermark corners, as x/y expressions over the main size (W, H) and the
+// overlay size (w, h)
+var watermarkPositions = map[string][2]string{
+	"top-left":     {"M", "M"},
+	"top-right":    {"W-w-M", "M"},
+	"bottom-left":  {"M", "H-h-M"},
+	"bottom-right": {"W-w-M", "H-h-M"},
+	"center":       {"(W-w)/2", "(H-h)/2"},
+}
+
+func (o *ConversionOptions) parseWatermarkOptions(get func(string) string) error {
+	var err error
+
+	text := strings.TrimSpace(strings.NewReplacer("\r", " ", "\n", " ").Replace(get("watermark_text")))
+	if len(text) > MaxWatermarkTextLength {
+		return fmt.Errorf("watermark_text is longer than %d characters", MaxWatermarkTextLength)
+	}
+	o.WatermarkText = text
+
+	if v := get("watermark_image"); v != "" {
+		if o.WatermarkImage, err = strconv.ParseBool(v); err != nil {
+			return fmt.Errorf("watermark_image must be true or false")
+		}
+	}
+
+	if !o.HasWatermark() {
+		return nil
+	}
+
+	o.WatermarkPosition = "bottom-right"
+	if v := strings.ToLower(get("watermark_position")); v != "" {
+		if _, ok := watermarkPositions[v]; !ok {
+			return fmt.Errorf("watermark_position must be top-left, top-right, bottom-left, bottom-right or center")
+		}
+		o.WatermarkPosition = v
+	}
+
+	o.WatermarkOpacity = DefaultWatermarkAlpha
+	if v := get("watermark_opacity"); v != "" {
+		o.WatermarkOpacity, err = strconv.ParseFloat(v, 64)
+		if err != nil || o.WatermarkOpacity <= 0 || o.WatermarkOpacity > 1 {
+			return fmt.Errorf("watermark_opacity must be between 0 and 1")
+		}
+	}
+
+	o.WatermarkScale = DefaultWatermarkScale
+	if v := get("watermark_scale"); v != "" {
+		o.WatermarkScale, err = strconv.ParseFloat(v, 64)
+		if err != nil || o.WatermarkScale < 0.02 || o.WatermarkScale > 1 {
+			return fmt.Errorf("watermark_scale must be between 0.02 and 1")
+		}
+	}
+
+	if v := get("watermark_start"); v != "" {
+		if o.WatermarkStart, err = parseTimestamp(v); err != nil {
+			return fmt.Errorf("invalid watermark_start: %v", err)
+		}
+	}
+	if v := get("watermark_end"); v != "" {
+		if o.WatermarkEnd, err = parseTimestamp(v); err != nil {
+			return fmt.Errorf("invalid watermark_end: %v", err)
+		}
+		if o.WatermarkEnd <= o.WatermarkStart {
+			return fmt.Errorf("watermark_end must be after watermark_start")
+		}
+	}
+
+	return nil
+}
+
+// HasWatermark reports whether a logo or text overlay is requested
+func (o ConversionOptions) HasWatermark() bool {
+	return o.WatermarkText != "" || o.WatermarkImage
+}
+
+// watermarkEnable limits the overlay to its time window, measured on the
+// output timeline (after trimming). Returns "" when always shown.
+func (o ConversionOptions) watermarkEnable() string {
+	switch {
+	case o.WatermarkStart > 0 && o.WatermarkEnd > 0:
+		return fmt.Sprintf(":enable='between(t,%s,%s)'", formatSeconds(o.WatermarkStart), formatSeconds(o.WatermarkEnd))
+	case o.WatermarkStart > 0:
+		return fmt.Sprintf(":enable='gte(t,%s)'", formatSeconds(o.WatermarkStart))
+	case o.WatermarkEnd > 0:
+		return fmt.Sprintf(":enable='lte(t,%s)'", formatSeconds(o.WatermarkEnd))
+	}
+	return ""
+}
+
+// watermarkXY returns the overlay coordinates for the chosen corner, with
+// the size variable names of the filter using them
+func (o ConversionOptions) watermarkXY(mainW, mainH, overW, overH string) (string, string) {
+	pos := watermarkPositions[o.WatermarkPosition]
+	replacer := strings.NewReplacer("W", mainW, "H", mainH, "w", overW, "h", overH, "M", strconv.Itoa(WatermarkMargin))
+	return replacer.Replace(pos[0]), replacer.Replace(pos[1])
+}
+
+// watermarkTextFilter draws the text watermark; its size follows the video
+// height so it looks the same at any resolution
+func (o ConversionOptions) watermarkTextFilter() string {
+	x, y := o.watermarkXY("w", "h", "tw", "th")
+	return fmt.Sprintf("drawtext=font=Sans:expansion=none:text=%s:fontsize=h*%.3f:"+
+		"fontcolor=white@%.2f:shadowcolor=black@%.2f:shadowx=2:shadowy=2:x=%s:y=%s%s",
+		escapeFilterValue(o.WatermarkText), o.WatermarkScale/3, o.WatermarkOpacity, o.WatermarkOpacity*0.6, x, y, o.watermarkEnable())
+}
+
+// watermarkImageGraph overlays the logo (input idx) on [base]. The logo is
+// scaled relative to the video with scale2ref so it composes with whatever
+// crop/scale came before it.
+func (o ConversionOptions) watermarkImageGraph(idx int) string {
+	x, y := o.watermarkXY("W", "H", "w", "h")
+	return fmt.Sprintf("[%d:v]format=rgba,colorchannelmixer=aa=%.2f[wm];"+
+		"[wm][base]scale2ref=w='main_w*%.3f':h='ow/a'[wmsized][ref];"+
+		"[ref][wmsized]overlay=x=%s:y=%s%s",
+		idx, o.WatermarkOpacity, o.WatermarkScale, x, y, o.watermarkEnable())
+}
+
+// watermarkInputArgs adds the logo as an extra input after any sidecar
+func watermarkInputArgs(job *Job) []string {
+	if job.WatermarkPath == "" || !job.Options.WatermarkImage {
+		return nil
+	}
+	return []string{"-i", job.WatermarkPath}
+}
+
+func watermarkInputIndex(job *Job) int {
+	if sidecarInputArgs(job) != nil {
+		return 2
+	}
+	return 1
+}
+
+// saveWatermarkImage validates an uploaded PNG and writes it to path
+func saveWatermarkImage(r io.Reader, path string) error {
+	data, err := io.ReadAll(io.LimitReader(r, MaxWatermarkSize+1))
+	if err != nil {
+		return err
+	}
+	if len(data) > MaxWatermarkSize {
+		return fmt.Errorf("watermark is larger than %d MB", MaxWatermarkSize/(1024*1024))
+	}
+
+	config, err := png.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("watermark must be a PNG image")
+	}
+	if config.Width > MaxWatermarkDimension || config.Height > MaxWatermarkDimension {
+		return fmt.Errorf("watermark must be at most %dx%d pixels", MaxWatermarkDimension, MaxWatermarkDimension)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// resolveWatermarkImage picks the logo for a job that asked for one: the
+// per-job upload if present, otherwise the owner's default logo
+func resolveWatermarkImage(job *Job, owner string) error {
+	if !job.Options.WatermarkImage || job.WatermarkPath != "" {
+		return nil
+	}
+	if owner != "" {
+		if path := defaultsStore.WatermarkPath(owner); fileExists(path) {
+			job.WatermarkPath = path
+			return nil
+		}
+	}
+	return fmt.Errorf("watermark_image is set but no watermark PNG was uploaded")
+}
+
+// jobWatermarkPath is where a per-job logo upload is stored
+func jobWatermarkPath(job *Job) string {
+	return filepath.Join(UploadDir, job.ID+"_watermark.png")
+}
+
+// watermarkSettingKeys are the defaults /watermark manages for a chat
+var watermarkSettingKeys = []string{
+	"watermark_text", "watermark_image", "watermark_position", "watermark_opacity",
+	"watermark_scale", "watermark_start", "watermark_end",
+}
+
+// handleTelegramWatermark sets the chat's default watermark, e.g.
+// "/watermark © Bitzy position=top-left opacity=0.5" or "/watermark off"
+func handleTelegramWatermark(chatID int64, args string) {
+	owner := telegramOwner(chatID)
+	args = strings.TrimSpace(args)
+
+	if args == "" {
+		text := "Usage: /watermark <text> [position=bottom-right] [opacity=0.8] [scale=0.15] [start=0:05] [end=0:30]\n" +
+			"Send a PNG as a file with the caption /watermark to use a logo.\n/watermark off removes it."
+		if current := formatWatermarkDefaults(defaultsStore.Get(owner)); current != "" {
+			text = "Current watermark: " + current + "\n\n" + text
+		}
+		telegramBot.Send(tgbotapi.NewMessage(chatID, text))
+		return
+	}
+
+	updates := make(map[string]string)
+	if strings.EqualFold(args, "off") {
+		for _, key := range watermarkSettingKeys {
+			updates[key] = ""
+		}
+		os.Remove(defaultsStore.WatermarkPath(owner))
+	} else {
+		words := make([]string, 0)
+		for _, token := range strings.Fields(args) {
+			key, value, ok := strings.Cut(token, "=")
+			if !ok {
+				words = append(words, token)
+				continue
+			}
+			key = "watermark_" + strings.ToLower(key)
+			if !isConversionOptionKey(key) || key == "watermark_text" || key == "watermark_image" {
+				telegramBot.Send(tgbotapi.NewMessage(chatID, "❌ Unknown setting "+token))
+				return
+			}
+			updates[key] = value
+		}
+		if len(words) > 0 {
+			updates["watermark_text"] = strings.Join(words, " ")
+		}
+	}
+
+	if err := defaultsStore.Update(owner, updates); err != nil {
+		telegramBot.Send(tgbotapi.NewMessage(chatID, "❌ "+err.Error()))
+		return
+	}
+
+	text := "✅ Watermark removed"
+	if current := formatWatermarkDefaults(defaultsStore.Get(owner)); current != "" {
+		text = "✅ Watermark set: " + current
+	}
+	telegramBot.Send(tgbotapi.NewMessage(chatID, text))
+}
+
+// handleTelegramWatermarkLogo stores a PNG document as the chat's logo
+func handleTelegramWatermarkLogo(message *tgbotapi.Message) {
+	chatID := message.Chat.ID
+	owner := telegramOwner(chatID)
+	doc := message.Document
+
+	if !strings.HasSuffix(strings.ToLower(doc.FileName), ".png") || doc.FileSize > MaxWatermarkSize {
+		telegramBot.Send(tgbotapi.NewMessage(chatID, "❌ The watermark must be a PNG file up to 5MB (send it as a file, not a photo)"))
+		return
+	}
+
+	tempPath := filepath.Join(TempDir, fmt.Sprintf("tg_%d_watermark.png", chatID))
+	defer os.Remove(tempPath)
+	if err := fetchTelegramFile(doc.FileID, tempPath); err != nil {
+		telegramBot.Send(tgbotapi.NewMessage(chatID, "❌ Download failed: "+err.Error()))
+		return
+	}
+
+	f, err := os.Open(tempPath)
+	if err != nil {
+		telegramBot.Send(tgbotapi.NewMessage(chatID, "❌ Download failed"))
+		return
+	}
+	defer f.Close()
+
+	if err := saveWatermarkImage(f, defaultsStore.WatermarkPath(owner)); err != nil {
+		telegramBot.Send(tgbotapi.NewMessage(chatID, "❌ "+err.Error()))
+		return
+	}
+	if err := defaultsStore.Update(owner, map[string]string{"watermark_image": "true"}); err != nil {
+		telegramBot.Send(tgbotapi.NewMessage(chatID, "❌ "+err.Error()))
+		return
+	}
+
+	telegramBot.Send(tgbotapi.NewMessage(chatID, "✅ Logo saved; it will be added to your conversions. /watermark off removes it."))
+}
+
+// formatWatermarkDefaults summarizes the watermark part of a defaults map
+func formatWatermarkDefaults(defaults map[string]string) string {
+	parts := make([]string, 0)
+	if defaults["watermark_image"] == "true" {
+		parts = append(parts, "logo")
+	}
+	if text := defaults["watermark_text"]; text != "" {
+		parts = append(parts, fmt.Sprintf("%q", text))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	for _, key := range watermarkSettingKeys[2:] {
+		if v := defaults[key]; v != "" {
+			parts = append(parts, strings.TrimPrefix(key, "watermark_")+"="+v)
+		}
+	}
+	return strings.Join(parts, " ")
+}
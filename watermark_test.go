@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseWatermarkOptions(t *testing.T) {
+	tests := []struct {
+		params  map[string]string
+		wantErr bool
+	}{
+		{map[string]string{}, false},
+		{map[string]string{"watermark_text": "© me", "watermark_position": "Top-Left", "watermark_opacity": "0.5"}, false},
+		{map[string]string{"watermark_image": "true", "watermark_scale": "0.3", "watermark_start": "5", "watermark_end": "0:10"}, false},
+		{map[string]string{"watermark_text": strings.Repeat("x", MaxWatermarkTextLength+1)}, true},
+		{map[string]string{"watermark_image": "maybe"}, true},
+		{map[string]string{"watermark_text": "x", "watermark_position": "left"}, true},
+		{map[string]string{"watermark_text": "x", "watermark_opacity": "0"}, true},
+		{map[string]string{"watermark_text": "x", "watermark_scale": "2"}, true},
+		{map[string]string{"watermark_text": "x", "watermark_start": "10", "watermark_end": "5"}, true},
+		// Watermark settings without a watermark are ignored
+		{map[string]string{"watermark_position": "left"}, false},
+	}
+
+	for _, tt := range tests {
+		var opts ConversionOptions
+		err := opts.parseWatermarkOptions(func(key string) string { return tt.params[key] })
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseWatermarkOptions(%v) error = %v, wantErr %v", tt.params, err, tt.wantErr)
+		}
+	}
+}
+
+func TestWatermarkFilters(t *testing.T) {
+	opts := ConversionOptions{WatermarkPosition: "bottom-right", WatermarkText: "a:b", WatermarkOpacity: 0.5, WatermarkScale: 0.3}
+	if x, y := opts.watermarkXY("W", "H", "w", "h"); x != "W-w-16" || y != "H-h-16" {
+		t.Errorf("watermarkXY() = %s, %s", x, y)
+	}
+	if filter := opts.watermarkTextFilter(); !strings.Contains(filter, `text=a\\:b:`) || !strings.Contains(filter, "x=w-tw-16:y=h-th-16") {
+		t.Errorf("watermarkTextFilter() = %s", filter)
+	}
+
+	tests := []struct {
+		start, end float64
+		want       string
+	}{
+		{0, 0, ""},
+		{2, 0, ":enable='gte(t,2.000)'"},
+		{0, 3.5, ":enable='lte(t,3.500)'"},
+		{2, 3.5, ":enable='between(t,2.000,3.500)'"},
+	}
+	for _, tt := range tests {
+		opts.WatermarkStart, opts.WatermarkEnd = tt.start, tt.end
+		if got := opts.watermarkEnable(); got != tt.want {
+			t.Errorf("watermarkEnable(%v, %v) = %q, want %q", tt.start, tt.end, got, tt.want)
+		}
+	}
+}
+
+func TestSaveWatermarkImage(t *testing.T) {
+	encode := func(width, height int) []byte {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height))); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	dir := t.TempDir()
+
+	if err := saveWatermarkImage(bytes.NewReader(encode(8, 8)), filepath.Join(dir, "logo", "wm.png")); err != nil {
+		t.Errorf("valid PNG refused: %v", err)
+	}
+	if err := saveWatermarkImage(strings.NewReader("GIF89a"), filepath.Join(dir, "gif.png")); err == nil {
+		t.Error("non-PNG accepted")
+	}
+	if err := saveWatermarkImage(bytes.NewReader(encode(MaxWatermarkDimension+1, 1)), filepath.Join(dir, "wide.png")); err == nil {
+		t.Error("oversized PNG accepted")
+	}
+}
@@ -1,14 +1,18 @@
 class WebMConverter {
     constructor() {
         this.jobs = new Map();
+        this.spriteCues = new Map();
         this.files = [];
         this.ws = null;
+        this.eventSource = null;
         this.reconnectAttempts = 0;
+        this.lastSeq = 0;
+        this.wsFailures = 0;
+        this.requestId = 0;
         
         this.initElements();
         this.initEventListeners();
         this.connectWebSocket();
-        this.loadJobs();
     }
     
     initElements() {
@@ -16,11 +20,52 @@ class WebMConverter {
         this.fileInput = document.getElementById('fileInput');
         this.renameOptions = document.getElementById('renameOptions');
         this.customNameInput = document.getElementById('customNameInput');
+        this.trimStartInput = document.getElementById('trimStartInput');
+        this.trimEndInput = document.getElementById('trimEndInput');
+        this.transformInputs = {
+            max_width: document.getElementById('maxWidthInput'),
+            max_height: document.getElementById('maxHeightInput'),
+            rotate: document.getElementById('rotateSelect'),
+            flip: document.getElementById('flipSelect'),
+            crop: document.getElementById('cropInput'),
+            pad_aspect: document.getElementById('padAspectInput'),
+            target_size_mb: document.getElementById('targetSizeInput'),
+            quality: document.getElementById('qualitySelect'),
+            title: document.getElementById('titleInput'),
+            author: document.getElementById('authorInput'),
+            comment: document.getElementById('commentInput'),
+            subtitle_font: document.getElementById('subtitleFontInput'),
+            subtitle_size: document.getElementById('subtitleSizeInput'),
+            subtitle_position: document.getElementById('subtitlePositionSelect'),
+            watermark_text: document.getElementById('watermarkTextInput'),
+            watermark_position: document.getElementById('watermarkPositionSelect'),
+            watermark_opacity: document.getElementById('watermarkOpacityInput'),
+            watermark_scale: document.getElementById('watermarkScaleInput'),
+            output_mode: document.getElementById('outputModeSelect'),
+            renditions: document.getElementById('renditionsInput')
+        };
+        this.subtitleInput = document.getElementById('subtitleInput');
+        this.watermarkInput = document.getElementById('watermarkInput');
+        this.autoCropInput = document.getElementById('autoCropInput');
+        this.formatSelect = document.getElementById('formatSelect');
+        this.subtitlesSelect = document.getElementById('subtitlesSelect');
+        this.stripMetadataInput = document.getElementById('stripMetadataInput');
+        this.muteInput = document.getElementById('muteInput');
         this.uploadBtn = document.getElementById('uploadBtn');
         this.jobsList = document.getElementById('jobsList');
         this.queueCount = document.getElementById('queueCount');
         this.processingCount = document.getElementById('processingCount');
         this.downloadAllBtn = document.getElementById('downloadAllBtn');
+        this.batchStatus = document.getElementById('batchStatus');
+        this.cancelBatchBtn = document.getElementById('cancelBatchBtn');
+        this.batch = null;
+        this.playerView = document.getElementById('playerView');
+        this.playerTitle = document.getElementById('playerTitle');
+        this.playerSource = document.getElementById('playerSource');
+        this.playerSourcePane = document.getElementById('playerSourcePane');
+        this.playerOutput = document.getElementById('playerOutput');
+        this.playerSyncInput = document.getElementById('playerSyncInput');
+        this.playerOffset = 0;
     }
 
     initEventListeners() {
@@ -44,6 +89,26 @@ class WebMConverter {
         if (this.downloadAllBtn) {
             this.downloadAllBtn.addEventListener('click', () => this.downloadAll());
         }
+
+        // Sprite scrubbing over posters
+        this.jobsList.addEventListener('mousemove', this.handlePosterScrub.bind(this));
+        this.jobsList.addEventListener('mouseout', this.handlePosterLeave.bind(this));
+
+        // Side-by-side player and cancel buttons
+        this.jobsList.addEventListener('click', (e) => {
+            const button = e.target.closest('.watch-btn');
+            if (button) this.openPlayer(button.dataset.jobId);
+            const command = e.target.closest('.job-command');
+            if (command) this.jobCommand(command.dataset.command, command.dataset.jobId);
+        });
+        this.cancelBatchBtn.addEventListener('click', () => this.cancelBatch());
+        document.getElementById('playerCloseBtn').addEventListener('click', () => this.closePlayer());
+        document.addEventListener('keydown', (e) => {
+            if (e.key === 'Escape' && !this.playerView.hidden) this.closePlayer();
+        });
+        ['play', 'pause', 'seeked', 'ratechange'].forEach(event => {
+            this.playerOutput.addEventListener(event, () => this.syncPlayers());
+        });
     }
 
     handleDragOver(e) {
@@ -106,9 +171,17 @@ class WebMConverter {
 
         const renameOption = document.querySelector('input[name="rename"]:checked').value;
         const customName = this.customNameInput.value;
+        const options = this.getConversionOptions();
+
+        // Several files go into one batch with the shared options
+        let uploadUrl = '/api/upload';
+        if (this.files.length > 1) {
+            const batch = await this.createBatch(options);
+            if (batch) uploadUrl = `/api/batches/${batch.id}/files`;
+        }
 
         for (const file of this.files) {
-            await this.uploadFile(file, renameOption, customName);
+            await this.uploadFile(file, renameOption, customName, options, uploadUrl);
         }
 
         // Reset form
@@ -119,12 +192,100 @@ class WebMConverter {
         this.uploadBtn.disabled = false;
         this.uploadBtn.textContent = 'Start Conversion';
         this.customNameInput.value = '';
+        this.trimStartInput.value = '';
+        this.trimEndInput.value = '';
+        Object.values(this.transformInputs).forEach(input => input.value = '');
+        this.autoCropInput.checked = false;
+        this.stripMetadataInput.checked = false;
+        this.muteInput.checked = false;
+        this.subtitleInput.value = '';
+        this.watermarkInput.value = '';
+    }
+
+    getConversionOptions() {
+        const options = {};
+        const start = this.trimStartInput.value.trim();
+        const end = this.trimEndInput.value.trim();
+
+        if (start) options.start = start;
+        if (end) options.end = end;
+        if (start || end) {
+            options.trim_mode = document.querySelector('input[name="trimMode"]:checked').value;
+        }
+
+        Object.entries(this.transformInputs).forEach(([key, input]) => {
+            const value = input.value.trim();
+            if (value) options[key] = value;
+        });
+        if (this.autoCropInput.checked) options.autocrop = 'true';
+        if (this.muteInput.checked) options.mute = 'true';
+
+        options.format = this.formatSelect.value;
+        options.subtitles = this.subtitlesSelect.value;
+        if (this.stripMetadataInput.checked) {
+            options.metadata = 'strip';
+            options.chapters = 'false';
+        }
+
+        return options;
+    }
+
+    async createBatch(options) {
+        try {
+            const response = await fetch('/api/batches', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ options })
+            });
+            if (!response.ok) throw new Error(await response.text());
+            const batch = await response.json();
+            this.updateBatch(batch);
+            this.watch({ batches: [batch.id] });
+            return batch;
+        } catch (error) {
+            console.error('Batch creation failed:', error);
+            return null;
+        }
+    }
+
+    updateBatch(batch) {
+        this.batch = batch;
+        const finished = (batch.counts.completed || 0) + (batch.counts.failed || 0) + (batch.counts.cancelled || 0);
+        this.batchStatus.textContent = `Batch: ${finished}/${batch.total} · ${batch.progress}%`;
+        this.batchStatus.title = batch.state;
+        this.batchStatus.style.display = 'inline-block';
+        const active = ['queued', 'processing'].includes(batch.state);
+        this.cancelBatchBtn.style.display = active ? 'inline-block' : 'none';
+    }
+
+    async cancelBatch() {
+        if (!this.batch || !confirm('Cancel all remaining files in this batch?')) return;
+        const response = await fetch(`/api/batches/${this.batch.id}/cancel`, { method: 'POST' });
+        if (response.ok) this.updateBatch(await response.json());
+    }
+
+    // Cancel and retry go over the socket when it is open
+    // WebSocket commands need an API key, so the UI uses the REST endpoints
+    async jobCommand(type, jobId) {
+        const response = await fetch(`/api/jobs/${jobId}/${type}`, { method: 'POST' });
+        if (response.ok) {
+            this.updateJob(await response.json());
+        } else {
+            alert(await response.text());
+        }
     }
 
-    async uploadFile(file, renameOption, customName) {
+    async uploadFile(file, renameOption, customName, options = {}, uploadUrl = '/api/upload') {
         const formData = new FormData();
         formData.append('file', file);
         formData.append('rename', renameOption);
+        Object.entries(options).forEach(([key, value]) => formData.append(key, value));
+        if (this.subtitleInput.files.length > 0) {
+            formData.append('subtitle', this.subtitleInput.files[0]);
+        }
+        if (this.watermarkInput.files.length > 0) {
+            formData.append('watermark', this.watermarkInput.files[0]);
+        }
         
         if (renameOption === 'custom' && customName) {
             // For multiple files with custom name, add index
@@ -135,21 +296,22 @@ class WebMConverter {
         }
 
         try {
-            const response = await fetch('/api/upload', {
+            const response = await fetch(uploadUrl, {
                 method: 'POST',
                 body: formData
             });
 
             if (!response.ok) {
-                throw new Error('Upload failed');
+                throw new Error(await response.text() || 'Upload failed');
             }
 
             const job = await response.json();
             this.jobs.set(job.id, job);
             this.addJobToList(job);
+            this.watch({ jobs: [job.id] });
         } catch (error) {
             console.error('Upload error:', error);
-            alert(`Failed to upload ${file.name}`);
+            alert(`Failed to upload ${file.name}: ${error.message}`);
         }
     }
 
@@ -158,48 +320,93 @@ class WebMConverter {
         const wsUrl = `${protocol}//${window.location.host}/ws`;
         
         this.ws = new WebSocket(wsUrl);
+        let opened = false;
 
         this.ws.onopen = () => {
             console.log('WebSocket connected');
+            opened = true;
+            this.wsFailures = 0;
+            // The jobs we uploaded; after a reconnect only the missed events are replayed
+            this.sendCommand({ type: 'subscribe', ...this.subscription(), since: this.lastSeq });
         };
 
-        this.ws.onmessage = (event) => {
-            const data = JSON.parse(event.data);
-            
-            if (data.type === 'initial') {
-                data.jobs.forEach(job => {
-                    this.jobs.set(job.id, job);
-                    this.addJobToList(job);
-                });
-            } else if (data.type === 'update') {
-                this.updateJob(data.job);
-            }
-        };
+        this.ws.onmessage = (event) => this.handleMessage(JSON.parse(event.data));
 
         this.ws.onclose = () => {
             console.log('WebSocket disconnected');
+            // Proxies that break the upgrade never let us connect; use SSE instead
+            if (!opened && ++this.wsFailures >= 2) {
+                this.connectEventSource();
+                return;
+            }
             // Reconnect after 3 seconds
             setTimeout(() => this.connectWebSocket(), 3000);
         };
     }
 
-    async loadJobs() {
-        try {
-            const response = await fetch('/api/jobs');
-            const jobs = await response.json();
-            
-            jobs.forEach(job => {
-                this.jobs.set(job.id, job);
-                this.addJobToList(job);
-            });
-            
-            this.updateStats();
-        } catch (error) {
-            console.error('Failed to load jobs:', error);
+    // Server-Sent Events fallback. EventSource reconnects by itself and
+    // resumes from the last event ID.
+    connectEventSource() {
+        if (this.eventSource) {
+            this.eventSource.close();
+        } else {
+            console.log('Falling back to Server-Sent Events');
         }
+        this.ws = null;
+        const { jobs, batches } = this.subscription();
+        const params = new URLSearchParams({ jobs: jobs.join(','), batches: batches.join(',') });
+        if (this.lastSeq) params.set('last_event_id', this.lastSeq);
+        const source = new EventSource(`/api/events?${params}`);
+        this.eventSource = source;
+        ['snapshot', 'subscribed', 'job_update', 'batch_update'].forEach(type => {
+            source.addEventListener(type, (event) => this.handleMessage(JSON.parse(event.data)));
+        });
+    }
+
+    handleMessage(data) {
+        // hello carries the server's position, not ours
+        if (data.seq && data.type !== 'hello') this.lastSeq = data.seq;
+        
+        if (data.type === 'snapshot') {
+            data.jobs.forEach(job => this.addJobToList(job));
+            data.batches
+                .filter(batch => this.batch && batch.id === this.batch.id)
+                .forEach(batch => this.updateBatch(batch));
+        } else if (data.type === 'job_update') {
+            this.updateJob(data.job);
+        } else if (data.type === 'batch_update' && this.batch && data.batch.id === this.batch.id) {
+            this.updateBatch(data.batch);
+        } else if (data.type === 'ack' && data.job) {
+            this.updateJob(data.job);
+        } else if (data.type === 'error') {
+            alert(data.error);
+        }
+    }
+
+    // Updates are delivered only for the jobs and batch this page created
+    subscription() {
+        return {
+            jobs: Array.from(this.jobs.keys()),
+            batches: this.batch ? [this.batch.id] : []
+        };
+    }
+
+    watch(topics) {
+        if (this.ws && this.ws.readyState === WebSocket.OPEN) {
+            this.sendCommand({ type: 'subscribe', ...topics });
+        } else if (this.eventSource) {
+            // An event stream's topics are fixed, so open a new one
+            this.connectEventSource();
+        }
+    }
+
+    sendCommand(command) {
+        this.ws.send(JSON.stringify({ v: 1, id: String(++this.requestId), ...command }));
     }
 
     addJobToList(job) {
+        this.jobs.set(job.id, job);
+        
         // Check if job already exists
         if (document.getElementById(`job-${job.id}`)) {
             this.updateJob(job);
@@ -242,6 +449,18 @@ class WebMConverter {
         this.updateStats();
     }
 
+    // Speed and ETA of the running conversion, e.g. " · 2.1x · ETA 0:42"
+    formatStats(stats) {
+        if (!stats) return '';
+        let text = '';
+        if (stats.speed > 0) text += ` · ${stats.speed.toFixed(1)}x`;
+        if (stats.eta_seconds > 0) {
+            const eta = Math.round(stats.eta_seconds);
+            text += ` · ETA ${Math.floor(eta / 60)}:${String(eta % 60).padStart(2, '0')}`;
+        }
+        return text;
+    }
+
     renderJob(job) {
         const status = this.getStatusDisplay(job.status);
         const size = this.formatFileSize(job.filesize);
@@ -249,14 +468,21 @@ class WebMConverter {
         
         let html = `
             <div class="job-header">
-                <span class="job-name" title="${job.filename}">${job.filename}</span>
+                <span class="job-name" title="${this.escapeHtml(job.filename)}">${this.escapeHtml(job.filename)}</span>
                 <span class="job-status status-${job.status}">${status}</span>
             </div>
             <div class="job-details">
                 <div class="job-info">
-                    <span title="${job.output_name}">Output: ${this.truncateFilename(job.output_name)}</span>
+                    <span title="${this.escapeHtml(job.output_name)}">Output: ${this.escapeHtml(this.truncateFilename(job.output_name))}</span>
                     <span>${size}</span>
+                    ${this.renderClip(job.options)}
                     ${job.queue_position > 0 ? `<span>Queue: #${job.queue_position}</span>` : ''}
+                    ${['downloading', 'queued', 'processing'].includes(job.status)
+                        ? `<button type="button" class="preview-link job-command" data-command="cancel" data-job-id="${job.id}">Cancel</button>`
+                        : ''}
+                    ${['failed', 'cancelled'].includes(job.status)
+                        ? `<button type="button" class="preview-link job-command" data-command="retry" data-job-id="${job.id}">Retry</button>`
+                        : ''}
                 </div>
             </div>
         `;
@@ -264,11 +490,14 @@ class WebMConverter {
         if (job.status === 'processing') {
             const progressPercent = job.progress || 0;
             const timeElapsed = job.started_at ? this.getTimeElapsed(job.started_at) : '0s';
+            const retrying = job.attempts && job.attempts.length > 0
+                ? ` (retry: ${job.strategy})`
+                : '';
             
             html += `
                 <div class="progress-container">
                     <div class="progress-header">
-                        <span class="progress-label">Converting... ${timeElapsed}</span>
+                        <span class="progress-label">Converting${retrying}... ${timeElapsed}${this.formatStats(job.stats)}</span>
                         <span class="progress-percentage">${progressPercent}%</span>
                     </div>
                     <div class="progress-bar">
@@ -280,6 +509,7 @@ class WebMConverter {
 
         if (job.status === 'completed') {
             const duration = job.started_at ? this.getTimeElapsed(job.started_at, job.completed_at) : '';
+            html += this.renderPoster(job);
             html += `
                 <a href="/api/jobs/${job.id}/download" class="download-btn">
                     <svg class="download-icon" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2">
@@ -287,24 +517,192 @@ class WebMConverter {
                         <polyline points="7 10 12 15 17 10"></polyline>
                         <line x1="12" y1="15" x2="12" y2="3"></line>
                     </svg>
-                    Download MP4 ${duration ? `(${duration})` : ''}
+                    Download ${this.formatLabel(job.output_name)} ${duration ? `(${duration})` : ''}
                 </a>
             `;
+            if (!job.streaming) {
+                html += `<button type="button" class="preview-link watch-btn" data-job-id="${job.id}">Watch${job.source_available ? ' &amp; compare' : ''}</button>`;
+            }
+            html += this.renderStreaming(job);
+            html += this.renderSidecars(job);
         }
 
         if (job.error) {
-            html += `<div class="error-message" style="color: var(--error); font-size: 0.75rem; margin-top: 0.5rem;">${job.error}</div>`;
+            html += `<div class="error-message" style="color: var(--error); font-size: 0.75rem; margin-top: 0.5rem;">${this.escapeHtml(job.error)}</div>`;
+        }
+
+        if (job.status === 'failed' && job.attempts && job.attempts.length > 0) {
+            const attempts = job.attempts
+                .map(a => `<li title="${this.escapeHtml(a.error || '')}">${this.escapeHtml(a.strategy)}: ${this.escapeHtml(a.reason || 'ok')}</li>`)
+                .join('');
+            html += `<ul class="attempt-list">${attempts}</ul>`;
         }
 
         return html;
     }
 
+    renderPoster(job) {
+        const previews = job.previews;
+        if (!previews || !previews.poster) return '';
+
+        const animated = previews.animated
+            ? `<a href="${previews.animated}" target="_blank" class="preview-link">Animated preview</a>`
+            : '';
+        const info = `<a href="/api/jobs/${job.id}/info" target="_blank" class="preview-link">Media info</a>`;
+        return `
+            <div class="job-poster" data-job-id="${job.id}" data-vtt="${previews.sprite_vtt || ''}">
+                <img src="${previews.poster}" alt="Poster for ${this.escapeHtml(job.output_name)}" loading="lazy">
+                <div class="poster-scrub"></div>
+            </div>
+            ${animated}${info}
+        `;
+    }
+
+    async loadSpriteCues(url) {
+        if (this.spriteCues.has(url)) return this.spriteCues.get(url);
+
+        const cues = [];
+        this.spriteCues.set(url, cues);
+        try {
+            const text = await (await fetch(url)).text();
+            const pattern = /(\d+):(\d+):(\d+)\.(\d+) --> (\d+):(\d+):(\d+)\.(\d+)\n(\S+)#xywh=(\d+),(\d+),(\d+),(\d+)/g;
+            let m;
+            while ((m = pattern.exec(text)) !== null) {
+                cues.push({
+                    end: (+m[5]) * 3600 + (+m[6]) * 60 + (+m[7]) + (+m[8]) / 1000,
+                    url: m[9],
+                    x: +m[10], y: +m[11], w: +m[12], h: +m[13]
+                });
+            }
+        } catch (error) {
+            console.error('Failed to load sprite cues:', error);
+        }
+        return cues;
+    }
+
+    async handlePosterScrub(e) {
+        const poster = e.target.closest('.job-poster');
+        if (!poster || !poster.dataset.vtt) return;
+
+        const cues = await this.loadSpriteCues(poster.dataset.vtt);
+        if (cues.length === 0) return;
+
+        const rect = poster.getBoundingClientRect();
+        const fraction = Math.min(Math.max((e.clientX - rect.left) / rect.width, 0), 0.999);
+        const cue = cues[Math.floor(fraction * cues.length)];
+        const scrub = poster.querySelector('.poster-scrub');
+
+        scrub.style.display = 'block';
+        scrub.style.width = `${cue.w}px`;
+        scrub.style.height = `${cue.h}px`;
+        scrub.style.backgroundImage = `url(${cue.url})`;
+        scrub.style.backgroundPosition = `-${cue.x}px -${cue.y}px`;
+    }
+
+    handlePosterLeave(e) {
+        const poster = e.target.closest('.job-poster');
+        if (poster && !poster.contains(e.relatedTarget)) {
+            poster.querySelector('.poster-scrub').style.display = 'none';
+        }
+    }
+
+    openPlayer(jobId) {
+        const job = this.jobs.get(jobId);
+        if (!job) return;
+
+        this.playerTitle.textContent = job.output_name;
+        this.playerOutput.src = `/api/jobs/${job.id}/stream`;
+
+        // The converted clip starts at trim_start of the original
+        this.playerOffset = (job.options && job.options.trim_start) || 0;
+        this.playerSourcePane.hidden = !job.source_available;
+        if (job.source_available) {
+            this.playerSource.src = `/api/jobs/${job.id}/source`;
+            this.playerSource.currentTime = this.playerOffset;
+        }
+
+        this.playerView.hidden = false;
+    }
+
+    closePlayer() {
+        [this.playerOutput, this.playerSource].forEach(video => {
+            video.pause();
+            video.removeAttribute('src');
+            video.load();
+        });
+        this.playerView.hidden = true;
+    }
+
+    syncPlayers() {
+        if (!this.playerSyncInput.checked || this.playerSourcePane.hidden) return;
+
+        const output = this.playerOutput;
+        const source = this.playerSource;
+        source.playbackRate = output.playbackRate;
+        source.currentTime = output.currentTime + this.playerOffset;
+        if (output.paused) {
+            source.pause();
+        } else {
+            source.play().catch(() => {});
+        }
+    }
+
+    renderStreaming(job) {
+        const streaming = job.streaming;
+        if (!streaming) return '';
+        const heights = streaming.renditions.map(r => `${r.height}p`).join(' / ');
+        const manifest = streaming.manifest
+            ? `<a href="${streaming.manifest}" target="_blank" class="preview-link">DASH manifest</a>`
+            : '';
+        return `<a href="${streaming.master}" target="_blank" class="preview-link" title="${heights}">HLS playlist</a>${manifest}`;
+    }
+
+    renderSidecars(job) {
+        if (!job.sidecars || job.sidecars.length === 0) return '';
+        return job.sidecars
+            .map(name => `<a href="/api/jobs/${job.id}/sidecars/${encodeURIComponent(name)}" class="preview-link">${name}</a>`)
+            .join('');
+    }
+
+    escapeHtml(text) {
+        return String(text)
+            .replace(/&/g, '&amp;')
+            .replace(/</g, '&lt;')
+            .replace(/>/g, '&gt;')
+            .replace(/"/g, '&quot;')
+            .replace(/'/g, '&#39;');
+    }
+
+    formatLabel(filename) {
+        return filename.substring(filename.lastIndexOf('.') + 1).toUpperCase();
+    }
+
+    renderClip(options) {
+        if (!options || !(options.trim_start || options.trim_end || options.trim_duration)) return '';
+        const start = this.formatSeconds(options.trim_start || 0);
+        const end = options.trim_end
+            ? this.formatSeconds(options.trim_end)
+            : options.trim_duration
+                ? this.formatSeconds((options.trim_start || 0) + options.trim_duration)
+                : 'end';
+        return `<span>Clip: ${start}–${end}</span>`;
+    }
+
+    formatSeconds(seconds) {
+        const total = Math.floor(seconds);
+        const minutes = Math.floor(total / 60);
+        const secs = String(total % 60).padStart(2, '0');
+        return `${minutes}:${secs}`;
+    }
+
     getStatusDisplay(status) {
         const displays = {
             'queued': 'Queued',
             'processing': 'Processing',
             'completed': 'Completed',
-            'failed': 'Failed'
+            'failed': 'Failed',
+            'cancelled': 'Cancelled',
+            'downloading': 'Downloading'
         };
         return displays[status] || status;
     }
@@ -379,10 +777,16 @@ class WebMConverter {
             return;
         }
         
-        // Multiple files - request zip
+        // A finished batch has its own archive
+        if (this.batch && this.batch.download && completedJobs.every(j => j.batch_id === this.batch.id)) {
+            window.location.href = this.batch.download;
+            return;
+        }
+        
+        // Multiple files - get a signed link so the browser streams the zip to disk
         try {
             const jobIds = completedJobs.map(j => j.id);
-            const response = await fetch('/api/jobs/download-all', {
+            const response = await fetch('/api/jobs/download-all/sign', {
                 method: 'POST',
                 headers: {
                     'Content-Type': 'application/json'
@@ -391,15 +795,8 @@ class WebMConverter {
             });
             
             if (response.ok) {
-                const blob = await response.blob();
-                const url = window.URL.createObjectURL(blob);
-                const a = document.createElement('a');
-                a.href = url;
-                a.download = `converted_videos_${Date.now()}.zip`;
-                document.body.appendChild(a);
-                a.click();
-                document.body.removeChild(a);
-                window.URL.revokeObjectURL(url);
+                const { url } = await response.json();
+                window.location.href = url;
             } else {
                 alert('Failed to download files');
             }
@@ -0,0 +1,511 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// Webhook delivery settings
+const (
+	WebhookMaxAttempts    = 6
+	WebhookInitialBackoff = 5 * time.Second
+	WebhookTimeout        = 10 * time.Second
+	MaxWebhookDeliveries  = 1000
+)
+
+// webhookEvents are the job statuses that trigger a delivery
+var webhookEvents = map[string]bool{
+	"queued":     true,
+	"processing": true,
+	"completed":  true,
+	"failed":     true,
+	"cancelled":  true,
+}
+
+// webhookSecret signs deliveries that have no job or API key secret, from WEBHOOK_SECRET
+var webhookSecret string
+
+// webhookClient refuses private and loopback addresses when it connects
+var webhookClient = newPublicHTTPClient(WebhookTimeout)
+
+// WebhookEndpoint is a URL registered for every job of an API key
+type WebhookEndpoint struct {
+	URL    string `json:"url"`
+	Secret string `json:"secret"`
+}
+
+// WebhookStore keeps the per-owner webhook endpoints, persisted as JSON in DataDir
+type WebhookStore struct {
+	mu        sync.RWMutex
+	path      string
+	endpoints map[string]WebhookEndpoint
+}
+
+var webhookStore *WebhookStore
+
+func NewWebhookStore(path string) *WebhookStore {
+	store := &WebhookStore{
+		path:      path,
+		endpoints: make(map[string]WebhookEndpoint),
+	}
+
+	data, err := os.ReadFile(path)
+	if err == nil {
+		if err := json.Unmarshal(data, &store.endpoints); err != nil {
+			log.Printf("Warning: ignoring corrupt webhooks file %s: %v", path, err)
+		}
+	}
+	return store
+}
+
+func (s *WebhookStore) Get(owner string) (WebhookEndpoint, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	endpoint, exists := s.endpoints[owner]
+	return endpoint, exists
+}
+
+// Set registers the owner's endpoint; a nil endpoint removes it
+func (s *WebhookStore) Set(owner string, endpoint *WebhookEndpoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if endpoint == nil {
+		delete(s.endpoints, owner)
+	} else {
+		s.endpoints[owner] = *endpoint
+	}
+
+	data, err := json.MarshalIndent(s.endpoints, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// WebhookDelivery is one event sent to one URL, with every attempt made
+type WebhookDelivery struct {
+	ID        string           `json:"id"`
+	EventID   string           `json:"event_id"`
+	Event     string           `json:"event"`
+	JobID     string           `json:"job_id"`
+	URL       string           `json:"url"`
+	State     string           `json:"state"` // pending, delivered, failed
+	CreatedAt time.Time        `json:"created_at"`
+	ReplayOf  string           `json:"replay_of,omitempty"`
+	Attempts  []WebhookAttempt `json:"attempts"`
+
+	owner       string
+	secret      string
+	payload     []byte
+	maxAttempts int
+}
+
+// WebhookAttempt records one POST of a delivery
+type WebhookAttempt struct {
+	At         time.Time `json:"at"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DurationMs int64     `json:"duration_ms"`
+}
+
+// webhookLog holds the most recent deliveries, oldest first
+var webhookLog = struct {
+	sync.RWMutex
+	deliveries []*WebhookDelivery
+}{}
+
+// WebhookPayload is the JSON body POSTed to a webhook
+type WebhookPayload struct {
+	EventID    string          `json:"event_id"`
+	Event      string          `json:"event"`
+	OccurredAt time.Time       `json:"occurred_at"`
+	Job        json.RawMessage `json:"job"`
+}
+
+func loadWebhookSecret() string {
+	return os.Getenv("WEBHOOK_SECRET")
+}
+
+// parseWebhookURL accepts absolute http(s) URLs; an empty value means no
+// webhook. Literal private addresses are refused here, host names when
+// webhookClient connects.
+func parseWebhookURL(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("webhook_url must be an http or https URL")
+	}
+	host := strings.ToLower(u.Hostname())
+	if ip := net.ParseIP(host); (ip != nil && !isPublicIP(ip)) || host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return "", fmt.Errorf("webhook_url: %w", errPrivateAddress)
+	}
+	return u.String(), nil
+}
+
+// notifyWebhooks queues deliveries when the job reached a new status.
+// It is called from broadcastUpdate, so progress updates are ignored here
+// and sent by the ProgressReporter instead.
+func notifyWebhooks(job *Job) {
+	queue.mu.Lock()
+	status := job.Status
+	if status == job.webhookStatus || !webhookEvents[status] {
+		queue.mu.Unlock()
+		return
+	}
+	job.webhookStatus = status
+	queue.mu.Unlock()
+
+	sendWebhookEvent(job, "job."+status, WebhookMaxAttempts)
+}
+
+// notifyWebhookProgress sends a job.progress event. It is not retried
+// since the next progress event supersedes it.
+func notifyWebhookProgress(job *Job) {
+	sendWebhookEvent(job, "job.progress", 1)
+}
+
+// sendWebhookEvent delivers the job to its own webhook and its owner's
+func sendWebhookEvent(job *Job, event string, maxAttempts int) {
+	queue.mu.RLock()
+	jobJSON, err := json.Marshal(job)
+	jobURL, jobSecret, owner := job.WebhookURL, job.WebhookSecret, job.Owner
+	queue.mu.RUnlock()
+
+	if err != nil {
+		log.Printf("Webhook payload for job %s failed: %v", job.ID, err)
+		return
+	}
+
+	endpoint, hasEndpoint := webhookStore.Get(owner)
+	if jobURL == "" && !hasEndpoint {
+		return
+	}
+
+	payload, err := json.Marshal(WebhookPayload{
+		EventID:    uuid.New().String(),
+		Event:      event,
+		OccurredAt: time.Now(),
+		Job:        jobJSON,
+	})
+	if err != nil {
+		log.Printf("Webhook payload for job %s failed: %v", job.ID, err)
+		return
+	}
+
+	if jobURL != "" {
+		if jobSecret == "" && hasEndpoint {
+			jobSecret = endpoint.Secret
+		} else if jobSecret == "" {
+			jobSecret = webhookSecret
+		}
+		startWebhookDelivery(job.ID, owner, jobURL, jobSecret, payload, "", maxAttempts)
+	}
+	if hasEndpoint && endpoint.URL != jobURL {
+		startWebhookDelivery(job.ID, owner, endpoint.URL, endpoint.Secret, payload, "", maxAttempts)
+	}
+}
+
+// startWebhookDelivery records a delivery and sends it in the background
+func startWebhookDelivery(jobID, owner, target, secret string, payload []byte, replayOf string, maxAttempts int) *WebhookDelivery {
+	var event WebhookPayload
+	json.Unmarshal(payload, &event)
+
+	delivery := &WebhookDelivery{
+		ID:          uuid.New().String(),
+		EventID:     event.EventID,
+		Event:       event.Event,
+		JobID:       jobID,
+		URL:         target,
+		State:       "pending",
+		CreatedAt:   time.Now(),
+		ReplayOf:    replayOf,
+		Attempts:    make([]WebhookAttempt, 0),
+		owner:       owner,
+		secret:      secret,
+		payload:     payload,
+		maxAttempts: maxAttempts,
+	}
+
+	// Progress events are superseded by the next one; logging them would
+	// push out the status deliveries that replay exists for
+	if delivery.Event != "job.progress" {
+		webhookLog.Lock()
+		webhookLog.deliveries = append(webhookLog.deliveries, delivery)
+		if len(webhookLog.deliveries) > MaxWebhookDeliveries {
+			webhookLog.deliveries = webhookLog.deliveries[len(webhookLog.deliveries)-MaxWebhookDeliveries:]
+		}
+		webhookLog.Unlock()
+	}
+
+	go deliverWebhook(delivery)
+	return delivery
+}
+
+// deliverWebhook POSTs until a 2xx, retrying network errors, 408, 429
+// and 5xx with exponential backoff up to the delivery's maxAttempts
+func deliverWebhook(delivery *WebhookDelivery) {
+	backoff := WebhookInitialBackoff
+
+	for attempt := 1; ; attempt++ {
+		result, retry := postWebhook(delivery)
+
+		webhookLog.Lock()
+		delivery.Attempts = append(delivery.Attempts, result)
+		switch {
+		case result.Error == "" && result.StatusCode/100 == 2:
+			delivery.State = "delivered"
+		case !retry || attempt >= delivery.maxAttempts:
+			delivery.State = "failed"
+		}
+		state := delivery.State
+		webhookLog.Unlock()
+
+		if state != "pending" {
+			if state == "failed" {
+				log.Printf("Webhook %s for job %s to %s failed after %d attempts", delivery.Event, delivery.JobID, delivery.URL, attempt)
+			}
+			return
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// postWebhook sends one attempt. The signature is the hex HMAC-SHA256 of
+// "<timestamp>.<body>" so receivers can reject replays of old requests.
+func postWebhook(delivery *WebhookDelivery) (WebhookAttempt, bool) {
+	start := time.Now()
+	result := WebhookAttempt{At: start}
+
+	req, err := http.NewRequest(http.MethodPost, delivery.URL, bytes.NewReader(delivery.payload))
+	if err != nil {
+		result.Error = err.Error()
+		return result, false
+	}
+
+	timestamp := strconv.FormatInt(start.Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "webm2mp4-webhook")
+	req.Header.Set("X-Webhook-Event", delivery.Event)
+	req.Header.Set("X-Webhook-Delivery", delivery.ID)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	if delivery.secret != "" {
+		mac := hmac.New(sha256.New, []byte(delivery.secret))
+		mac.Write([]byte(timestamp + "."))
+		mac.Write(delivery.payload)
+		req.Header.Set("X-Webhook-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := webhookClient.Do(req)
+	result.DurationMs = time.Since(start).Milliseconds()
+	if err != nil {
+		result.Error = err.Error()
+		return result, !errors.Is(err, errPrivateAddress)
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	resp.Body.Close()
+
+	result.StatusCode = resp.StatusCode
+	retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests
+	return result, retry
+}
+
+// copyDeliveryLocked returns a snapshot safe to encode. Caller must hold webhookLog.
+func copyDeliveryLocked(delivery *WebhookDelivery) WebhookDelivery {
+	snapshot := *delivery
+	snapshot.Attempts = append([]WebhookAttempt(nil), delivery.Attempts...)
+	return snapshot
+}
+
+// findDelivery returns a delivery visible to owner
+func findDelivery(id, owner string) (*WebhookDelivery, bool) {
+	webhookLog.RLock()
+	defer webhookLog.RUnlock()
+
+	for _, delivery := range webhookLog.deliveries {
+		if delivery.ID == id && delivery.owner == owner {
+			return delivery, true
+		}
+	}
+	return nil, false
+}
+
+func newWebhookSecret() string {
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		log.Fatalf("Cannot generate webhook secret: %v", err)
+	}
+	return "whsec_" + hex.EncodeToString(secret)
+}
+
+// Webhook handlers
+
+func handleGetWebhook(w http.ResponseWriter, r *http.Request) {
+	owner, ok := apiKeyOwner(r)
+	if !ok {
+		http.Error(w, "Valid X-API-Key required", http.StatusUnauthorized)
+		return
+	}
+
+	endpoint, exists := webhookStore.Get(owner)
+	if !exists {
+		http.Error(w, "No webhook registered", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"url": endpoint.URL})
+}
+
+// handlePutWebhook registers {"url": "...", "secret": "..."} for the API key.
+// Without a secret one is generated; it is only returned by this call.
+func handlePutWebhook(w http.ResponseWriter, r *http.Request) {
+	owner, ok := apiKeyOwner(r)
+	if !ok {
+		http.Error(w, "Valid X-API-Key required", http.StatusUnauthorized)
+		return
+	}
+
+	var endpoint WebhookEndpoint
+	if err := json.NewDecoder(io.LimitReader(r.Body, 64*1024)).Decode(&endpoint); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	target, err := parseWebhookURL(endpoint.URL)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if target == "" {
+		http.Error(w, "url must be an http or https URL", http.StatusBadRequest)
+		return
+	}
+	endpoint.URL = target
+	if endpoint.Secret == "" {
+		endpoint.Secret = newWebhookSecret()
+	}
+
+	if err := webhookStore.Set(owner, &endpoint); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(endpoint)
+}
+
+func handleDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	owner, ok := apiKeyOwner(r)
+	if !ok {
+		http.Error(w, "Valid X-API-Key required", http.StatusUnauthorized)
+		return
+	}
+
+	if err := webhookStore.Set(owner, nil); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleListDeliveries lists the caller's deliveries, newest first,
+// optionally filtered with ?job_id= and ?state=
+func handleListDeliveries(w http.ResponseWriter, r *http.Request) {
+	owner, ok := apiKeyOwner(r)
+	if !ok {
+		http.Error(w, "Valid X-API-Key required", http.StatusUnauthorized)
+		return
+	}
+	jobID := r.URL.Query().Get("job_id")
+	state := r.URL.Query().Get("state")
+
+	webhookLog.RLock()
+	deliveries := make([]WebhookDelivery, 0)
+	for i := len(webhookLog.deliveries) - 1; i >= 0; i-- {
+		delivery := webhookLog.deliveries[i]
+		if delivery.owner != owner || (jobID != "" && delivery.JobID != jobID) || (state != "" && delivery.State != state) {
+			continue
+		}
+		deliveries = append(deliveries, copyDeliveryLocked(delivery))
+	}
+	webhookLog.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deliveries)
+}
+
+func handleGetDelivery(w http.ResponseWriter, r *http.Request) {
+	owner, ok := apiKeyOwner(r)
+	if !ok {
+		http.Error(w, "Valid X-API-Key required", http.StatusUnauthorized)
+		return
+	}
+	delivery, exists := findDelivery(mux.Vars(r)["id"], owner)
+	if !exists {
+		http.Error(w, "Delivery not found", http.StatusNotFound)
+		return
+	}
+
+	webhookLog.RLock()
+	snapshot := copyDeliveryLocked(delivery)
+	webhookLog.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"delivery": snapshot,
+		"payload":  json.RawMessage(delivery.payload),
+	})
+}
+
+// handleReplayDelivery sends a recorded payload again as a new delivery.
+// The event_id is kept so receivers can deduplicate.
+func handleReplayDelivery(w http.ResponseWriter, r *http.Request) {
+	owner, ok := apiKeyOwner(r)
+	if !ok {
+		http.Error(w, "Valid X-API-Key required", http.StatusUnauthorized)
+		return
+	}
+	original, exists := findDelivery(mux.Vars(r)["id"], owner)
+	if !exists {
+		http.Error(w, "Delivery not found", http.StatusNotFound)
+		return
+	}
+
+	delivery := startWebhookDelivery(original.JobID, original.owner, original.URL, original.secret, original.payload, original.ID, original.maxAttempts)
+
+	webhookLog.RLock()
+	snapshot := copyDeliveryLocked(delivery)
+	webhookLog.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(snapshot)
+}
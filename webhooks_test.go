@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestDeliveryHandlersRequireAPIKey(t *testing.T) {
+	apiKeys = []string{"test-key"}
+	defer func() { apiKeys = nil }()
+
+	owner := apiKeyOwnerForTest(t, "test-key")
+	webhookLog.Lock()
+	webhookLog.deliveries = []*WebhookDelivery{
+		{ID: "anon", JobID: "job-1", State: "delivered", payload: []byte(`{}`)},
+		{ID: "mine", JobID: "job-2", State: "delivered", owner: owner, payload: []byte(`{}`)},
+	}
+	webhookLog.Unlock()
+	defer func() {
+		webhookLog.Lock()
+		webhookLog.deliveries = nil
+		webhookLog.Unlock()
+	}()
+
+	router := mux.NewRouter()
+	router.HandleFunc("/api/webhooks/deliveries", handleListDeliveries)
+	router.HandleFunc("/api/webhooks/deliveries/{id}", handleGetDelivery)
+	router.HandleFunc("/api/webhooks/deliveries/{id}/replay", handleReplayDelivery)
+
+	for _, tt := range []struct{ method, path string }{
+		{http.MethodGet, "/api/webhooks/deliveries"},
+		{http.MethodGet, "/api/webhooks/deliveries/anon"},
+		{http.MethodPost, "/api/webhooks/deliveries/anon/replay"},
+	} {
+		for _, key := range []string{"", "wrong-key"} {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if key != "" {
+				req.Header.Set("X-API-Key", key)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			if rec.Code != http.StatusUnauthorized {
+				t.Errorf("%s %s with key %q = %d, want 401", tt.method, tt.path, key, rec.Code)
+			}
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/webhooks/deliveries", nil)
+	req.Header.Set("X-API-Key", "test-key")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	var listed []WebhookDelivery
+	if err := json.NewDecoder(rec.Body).Decode(&listed); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("listing = %d, %v", rec.Code, err)
+	}
+	if len(listed) != 1 || listed[0].ID != "mine" {
+		t.Errorf("listed %+v, want only the key's own delivery", listed)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/webhooks/deliveries/anon", nil)
+	req.Header.Set("X-API-Key", "test-key")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("reading another owner's delivery = %d, want 404", rec.Code)
+	}
+}
+
+func apiKeyOwnerForTest(t *testing.T, key string) string {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-API-Key", key)
+	owner, ok := apiKeyOwner(req)
+	if !ok {
+		t.Fatalf("key %q not accepted", key)
+	}
+	return owner
+}
+
+func TestParseWebhookURL(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"", "", false},
+		{"https://hooks.example.com/convert", "https://hooks.example.com/convert", false},
+		{"http://93.184.216.34:8080/hook", "http://93.184.216.34:8080/hook", false},
+		{"ftp://example.com/", "", true},
+		{"/relative", "", true},
+		{"http://127.0.0.1/hook", "", true},
+		{"http://localhost:2424/api/jobs", "", true},
+		{"http://api.localhost/", "", true},
+		{"http://169.254.169.254/latest/meta-data", "", true},
+		{"http://10.0.0.5/", "", true},
+		{"http://192.168.1.10/", "", true},
+		{"http://[::1]/", "", true},
+		{"http://[fe80::1]/", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseWebhookURL(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseWebhookURL(%q) = %q, %v; want %q, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestWebhookToLoopbackIsNotRetried(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("webhook reached a loopback server")
+	}))
+	defer server.Close()
+
+	result, retry := postWebhook(&WebhookDelivery{ID: "d", URL: server.URL, payload: []byte(`{}`)})
+	if result.Error == "" || retry {
+		t.Errorf("postWebhook() = %+v, retry %v; want a final error", result, retry)
+	}
+}
+
+func TestProgressDeliveriesAreNotLogged(t *testing.T) {
+	webhookLog.Lock()
+	webhookLog.deliveries = nil
+	webhookLog.Unlock()
+
+	for i := 0; i < MaxWebhookDeliveries+10; i++ {
+		startWebhookDelivery("job", "owner", "http://127.0.0.1:1/", "", []byte(`{"event":"job.progress"}`), "", 1)
+	}
+	completed := startWebhookDelivery("job", "owner", "http://127.0.0.1:1/", "", []byte(`{"event":"job.completed"}`), "", 1)
+
+	webhookLog.RLock()
+	defer webhookLog.RUnlock()
+	if len(webhookLog.deliveries) != 1 || webhookLog.deliveries[0] != completed {
+		t.Errorf("log holds %d deliveries, want only the job.completed one", len(webhookLog.deliveries))
+	}
+}
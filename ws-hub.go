@@ -0,0 +1,470 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// WebSocket connection settings
+const (
+	wsWriteWait      = 10 * time.Second
+	wsPongWait       = 60 * time.Second
+	wsPingPeriod     = wsPongWait * 9 / 10
+	wsMaxMessageSize = 64 * 1024
+	wsSendBuffer     = 256
+	wsHistorySize    = 1024
+)
+
+// WSProtocolVersion is sent as "v" in every server message
+const WSProtocolVersion = 1
+
+// Event is a job or batch update. The hub numbers events in the order it
+// publishes them; Owner, JobID and BatchID decide who receives them.
+type Event struct {
+	Seq     uint64
+	Type    string // job_update, batch_update
+	JobID   string
+	BatchID string
+	Owner   string
+	Payload json.RawMessage
+	// Final marks a job reaching completed, failed or cancelled; listeners
+	// never miss these
+	Final bool
+
+	data []byte
+}
+
+// Hub owns the set of WebSocket and SSE clients. Only its run goroutine touches the
+// clients and their subscriptions; each client has a writer goroutine, so a
+// connection is never written to concurrently and a slow client cannot
+// block broadcasts.
+type Hub struct {
+	clients    map[*Client]bool
+	register   chan *Client
+	unregister chan *Client
+	events     chan *Event
+	requests   chan clientRequest
+	direct     chan clientMessage
+	listen     chan chan *Event
+
+	seq     uint64
+	history []*Event
+
+	// In-process consumers of every event, such as the Telegram notifier
+	listeners []chan *Event
+}
+
+// Client is one WebSocket or SSE connection with its buffered send queue
+type Client struct {
+	hub   *Hub
+	conn  *websocket.Conn // nil for SSE clients
+	send  chan []byte
+	owner string
+	addr  string
+
+	// Subscriptions, only touched by the hub goroutine
+	jobs    map[string]bool
+	batches map[string]bool
+	mine    bool
+}
+
+// ClientMessage is a command sent by a client, e.g.
+// {"v":1,"id":"1","type":"subscribe","mine":true,"since":42}
+type ClientMessage struct {
+	V       int      `json:"v"`
+	ID      string   `json:"id,omitempty"`
+	Type    string   `json:"type"` // subscribe, unsubscribe, cancel, retry, ping
+	Jobs    []string `json:"jobs,omitempty"`
+	Batches []string `json:"batches,omitempty"`
+	Mine    bool     `json:"mine,omitempty"`
+	Since   uint64   `json:"since,omitempty"`
+	JobID   string   `json:"job_id,omitempty"`
+}
+
+type clientRequest struct {
+	client  *Client
+	message ClientMessage
+}
+
+type clientMessage struct {
+	client *Client
+	data   []byte
+}
+
+var hub = NewHub()
+
+func NewHub() *Hub {
+	return &Hub{
+		clients:    make(map[*Client]bool),
+		register:   make(chan *Client),
+		unregister: make(chan *Client),
+		events:     make(chan *Event, wsSendBuffer),
+		requests:   make(chan clientRequest),
+		direct:     make(chan clientMessage, wsSendBuffer),
+		listen:     make(chan chan *Event),
+		history:    make([]*Event, 0, wsHistorySize),
+	}
+}
+
+func (h *Hub) run() {
+	for {
+		select {
+		case client := <-h.register:
+			h.clients[client] = true
+			h.sendTo(client, serverMessage("hello", "", map[string]interface{}{"seq": h.seq}))
+
+		case client := <-h.unregister:
+			h.remove(client)
+
+		case event := <-h.events:
+			h.seq++
+			event.Seq = h.seq
+			event.data = event.encode()
+			h.history = append(h.history, event)
+			if len(h.history) > wsHistorySize {
+				h.history = h.history[len(h.history)-wsHistorySize:]
+			}
+
+			for client := range h.clients {
+				if client.matches(event.JobID, event.BatchID, event.Owner) {
+					h.sendTo(client, event.data)
+				}
+			}
+			for _, listener := range h.listeners {
+				if event.Final {
+					listener <- event
+					continue
+				}
+				select {
+				case listener <- event:
+				default:
+					log.Printf("Event listener too slow, dropped event %d", event.Seq)
+				}
+			}
+
+		case listener := <-h.listen:
+			h.listeners = append(h.listeners, listener)
+
+		case request := <-h.requests:
+			if h.clients[request.client] {
+				h.handleSubscription(request.client, request.message)
+			}
+
+		case message := <-h.direct:
+			if h.clients[message.client] {
+				h.sendTo(message.client, message.data)
+			}
+		}
+	}
+}
+
+// sendTo queues data for the client, dropping it if it cannot keep up
+func (h *Hub) sendTo(client *Client, data []byte) {
+	select {
+	case client.send <- data:
+	default:
+		// Slow consumer: drop it rather than buffer without bound
+		log.Printf("Event client %s too slow, disconnecting", client.addr)
+		h.remove(client)
+	}
+}
+
+// remove closes the client's send queue, which stops its writer
+func (h *Hub) remove(client *Client) {
+	if h.clients[client] {
+		delete(h.clients, client)
+		close(client.send)
+	}
+}
+
+// Listen returns a channel receiving every event published from now on.
+// The receiver must keep up: progress events are dropped when the buffer
+// is full, while final events wait for room and hold up the hub meanwhile.
+func (h *Hub) Listen(buffer int) <-chan *Event {
+	listener := make(chan *Event, buffer)
+	h.listen <- listener
+	return listener
+}
+
+// Publish queues an event for numbering and delivery to subscribers
+func (h *Hub) Publish(event *Event) {
+	h.events <- event
+}
+
+// handleSubscription updates the client's topics. Subscribing answers with
+// the missed events when "since" is still in the history, otherwise with a
+// snapshot of the current state.
+func (h *Hub) handleSubscription(client *Client, message ClientMessage) {
+	enabled := message.Type == "subscribe"
+	for _, id := range message.Jobs {
+		setTopic(client.jobs, id, enabled)
+	}
+	for _, id := range message.Batches {
+		setTopic(client.batches, id, enabled)
+	}
+	if message.Mine {
+		client.mine = enabled
+	}
+
+	if !enabled {
+		h.sendTo(client, serverMessage("unsubscribed", message.ID, map[string]interface{}{"seq": h.seq}))
+		return
+	}
+
+	if message.Since > 0 && message.Since <= h.seq && (len(h.history) == 0 || h.history[0].Seq <= message.Since+1) {
+		missed := make([]*Event, 0)
+		for _, event := range h.history {
+			if event.Seq > message.Since && client.matches(event.JobID, event.BatchID, event.Owner) {
+				missed = append(missed, event)
+			}
+		}
+		// A long replay would overflow the send queue; a snapshot is smaller
+		if len(missed) <= wsSendBuffer/2 {
+			for _, event := range missed {
+				h.sendTo(client, event.data)
+			}
+			h.sendTo(client, serverMessage("subscribed", message.ID, map[string]interface{}{"seq": h.seq, "replayed": len(missed)}))
+			return
+		}
+	}
+
+	h.sendTo(client, h.snapshot(client, message.ID))
+}
+
+// snapshot encodes every job and batch the client is subscribed to
+func (h *Hub) snapshot(client *Client, requestID string) []byte {
+	queue.mu.RLock()
+	defer queue.mu.RUnlock()
+
+	jobs := make([]*Job, 0)
+	seen := make(map[string]bool)
+	addJob := func(job *Job) {
+		if !seen[job.ID] && client.matches(job.ID, job.BatchID, job.Owner) {
+			seen[job.ID] = true
+			jobs = append(jobs, job)
+		}
+	}
+
+	for _, job := range queue.jobs {
+		addJob(job)
+	}
+	for _, job := range queue.processing {
+		addJob(job)
+	}
+	for _, job := range queue.completed {
+		addJob(job)
+	}
+	for _, job := range queue.failed {
+		addJob(job)
+	}
+
+	batches := make([]BatchStatus, 0)
+	for _, batch := range queue.batches {
+		for _, job := range batch.jobs {
+			addJob(job)
+		}
+		if client.batches[batch.ID] || client.owns(batch.Owner) {
+			batches = append(batches, batch.statusLocked())
+		}
+	}
+
+	return serverMessage("snapshot", requestID, map[string]interface{}{
+		"seq":     h.seq,
+		"jobs":    jobs,
+		"batches": batches,
+	})
+}
+
+func setTopic(topics map[string]bool, id string, enabled bool) {
+	if enabled {
+		topics[id] = true
+	} else {
+		delete(topics, id)
+	}
+}
+
+// matches reports whether the client subscribed to a job or batch update
+func (c *Client) matches(jobID, batchID, owner string) bool {
+	return (jobID != "" && c.jobs[jobID]) ||
+		(batchID != "" && c.batches[batchID]) ||
+		c.owns(owner)
+}
+
+// owns reports whether "mine" covers an owner. Clients without an API key
+// share the empty owner, so they only get the jobs they list.
+func (c *Client) owns(owner string) bool {
+	return c.mine && c.owner != "" && owner == c.owner
+}
+
+func (e *Event) encode() []byte {
+	field := "job"
+	if e.Type == "batch_update" {
+		field = "batch"
+	}
+	return serverMessage(e.Type, "", map[string]interface{}{
+		"seq": e.Seq,
+		field: e.Payload,
+	})
+}
+
+// serverMessage adds the protocol version, type and request ID to fields
+func serverMessage(messageType, requestID string, fields map[string]interface{}) []byte {
+	if fields == nil {
+		fields = make(map[string]interface{})
+	}
+	fields["v"] = WSProtocolVersion
+	fields["type"] = messageType
+	if requestID != "" {
+		fields["id"] = requestID
+	}
+	data, err := json.Marshal(fields)
+	if err != nil {
+		log.Printf("WebSocket encoding failed: %v", err)
+	}
+	return data
+}
+
+// reply sends a message to this client only
+func (c *Client) reply(data []byte) {
+	c.hub.direct <- clientMessage{client: c, data: data}
+}
+
+func (c *Client) replyError(requestID, message string) {
+	c.reply(serverMessage("error", requestID, map[string]interface{}{"error": message}))
+}
+
+// handleCommand runs on the client's reader goroutine. Subscriptions go
+// to the hub; cancel and retry act on the client's own jobs, so they need
+// an API key.
+func (c *Client) handleCommand(message ClientMessage) {
+	if message.V != 0 && message.V != WSProtocolVersion {
+		c.replyError(message.ID, "unsupported protocol version")
+		return
+	}
+
+	switch message.Type {
+	case "subscribe", "unsubscribe":
+		c.hub.requests <- clientRequest{client: c, message: message}
+
+	case "cancel", "retry":
+		queue.mu.RLock()
+		job, exists := findJobLocked(message.JobID)
+		owned := exists && c.owner != "" && job.Owner == c.owner
+		queue.mu.RUnlock()
+
+		if !owned {
+			c.replyError(message.ID, "job not found")
+			return
+		}
+
+		if message.Type == "cancel" && !cancelJob(job) {
+			c.replyError(message.ID, "job already finished")
+			return
+		}
+		if message.Type == "retry" {
+			if err := retryJob(job); err != nil {
+				c.replyError(message.ID, err.Error())
+				return
+			}
+		}
+
+		queue.mu.RLock()
+		ack := serverMessage("ack", message.ID, map[string]interface{}{"command": message.Type, "job": job})
+		queue.mu.RUnlock()
+		c.reply(ack)
+
+	case "ping":
+		c.reply(serverMessage("pong", message.ID, nil))
+
+	default:
+		c.replyError(message.ID, "unknown message type")
+	}
+}
+
+// readPump reads commands, handles pongs and close frames, and unregisters
+// the client when the connection goes away
+func (c *Client) readPump() {
+	defer func() {
+		c.hub.unregister <- c
+		c.conn.Close()
+	}()
+
+	c.conn.SetReadLimit(wsMaxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var message ClientMessage
+		if err := json.Unmarshal(data, &message); err != nil {
+			c.replyError("", "invalid JSON")
+			continue
+		}
+		c.handleCommand(message)
+	}
+}
+
+// writePump is the only writer of the connection
+func (c *Client) writePump() {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case message, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if !ok {
+				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+				return
+			}
+
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// handleWebSocket serves /ws. The API key, if any, is taken from the
+// api_key query parameter since browsers cannot set WebSocket headers.
+func handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	owner, _ := apiKeyOwner(r)
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("WebSocket upgrade failed: %v", err)
+		return
+	}
+
+	client := &Client{
+		hub:     hub,
+		conn:    conn,
+		send:    make(chan []byte, wsSendBuffer),
+		owner:   owner,
+		addr:    conn.RemoteAddr().String(),
+		jobs:    make(map[string]bool),
+		batches: make(map[string]bool),
+	}
+	hub.register <- client
+
+	go client.writePump()
+	client.readPump()
+}
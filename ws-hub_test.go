@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// receive reads the next message queued for a test client
+func receive(t *testing.T, client *Client) map[string]interface{} {
+	t.Helper()
+	select {
+	case data, ok := <-client.send:
+		if !ok {
+			t.Fatal("send queue closed")
+		}
+		var message map[string]interface{}
+		if err := json.Unmarshal(data, &message); err != nil {
+			t.Fatal(err)
+		}
+		return message
+	case <-time.After(2 * time.Second):
+		t.Fatal("no message from the hub")
+	}
+	return nil
+}
+
+func newTestClient(h *Hub, owner string) *Client {
+	return &Client{hub: h, send: make(chan []byte, wsSendBuffer), owner: owner,
+		jobs: make(map[string]bool), batches: make(map[string]bool)}
+}
+
+func TestClientMatches(t *testing.T) {
+	client := newTestClient(nil, "key-a")
+	setTopic(client.jobs, "job-1", true)
+	setTopic(client.batches, "batch-1", true)
+
+	tests := []struct {
+		jobID, batchID, owner string
+		mine                  bool
+		want                  bool
+	}{
+		{"job-1", "", "key-b", false, true},
+		{"job-2", "batch-1", "key-b", false, true},
+		{"job-2", "", "key-a", false, false},
+		{"job-2", "", "key-a", true, true},
+		{"job-2", "", "key-b", true, false},
+		{"", "", "", false, false},
+	}
+
+	for _, tt := range tests {
+		client.mine = tt.mine
+		if got := client.matches(tt.jobID, tt.batchID, tt.owner); got != tt.want {
+			t.Errorf("matches(%q, %q, %q) with mine=%v = %v, want %v", tt.jobID, tt.batchID, tt.owner, tt.mine, got, tt.want)
+		}
+	}
+
+	setTopic(client.jobs, "job-1", false)
+	if client.matches("job-1", "", "") {
+		t.Error("unsubscribed job still matches")
+	}
+
+	// Keyless clients share the empty owner, so "mine" must not match it
+	anonymous := newTestClient(nil, "")
+	anonymous.mine = true
+	setTopic(anonymous.jobs, "job-1", true)
+	if anonymous.matches("job-2", "", "") {
+		t.Error("keyless client matched another keyless job")
+	}
+	if !anonymous.matches("job-1", "", "") {
+		t.Error("keyless client lost its listed job")
+	}
+}
+
+func TestCommandsNeedAnOwner(t *testing.T) {
+	job := &Job{ID: "ws-owner-test", Status: "queued"}
+	queue.mu.Lock()
+	queue.jobs = append(queue.jobs, job)
+	queue.mu.Unlock()
+	defer func() {
+		queue.mu.Lock()
+		for i, j := range queue.jobs {
+			if j == job {
+				queue.jobs = append(queue.jobs[:i], queue.jobs[i+1:]...)
+				break
+			}
+		}
+		queue.mu.Unlock()
+	}()
+
+	h := NewHub()
+	for _, command := range []string{"cancel", "retry"} {
+		newTestClient(h, "").handleCommand(ClientMessage{ID: "1", Type: command, JobID: job.ID})
+		reply := <-h.direct
+		var message map[string]interface{}
+		if err := json.Unmarshal(reply.data, &message); err != nil {
+			t.Fatal(err)
+		}
+		if message["type"] != "error" {
+			t.Errorf("keyless %s got %v, want an error", command, message)
+		}
+	}
+	if job.Status != "queued" {
+		t.Errorf("job status = %q after keyless commands", job.Status)
+	}
+}
+
+func TestServerMessage(t *testing.T) {
+	var message map[string]interface{}
+	if err := json.Unmarshal(serverMessage("error", "7", map[string]interface{}{"error": "nope"}), &message); err != nil {
+		t.Fatal(err)
+	}
+	if message["v"] != float64(WSProtocolVersion) || message["type"] != "error" || message["id"] != "7" || message["error"] != "nope" {
+		t.Errorf("serverMessage() = %v", message)
+	}
+
+	message = nil
+	if err := json.Unmarshal(serverMessage("hello", "", nil), &message); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := message["id"]; ok {
+		t.Errorf("message without a request ID has id: %v", message)
+	}
+}
+
+func TestSubscribeReplaysMissedEvents(t *testing.T) {
+	h := NewHub()
+	go h.run()
+
+	// The watcher sees every event, so we know when the hub has numbered them
+	watcher := newTestClient(h, "")
+	watcher.jobs["job-1"], watcher.jobs["job-2"] = true, true
+	client := newTestClient(h, "")
+	for _, c := range []*Client{watcher, client} {
+		h.register <- c
+		if hello := receive(t, c); hello["type"] != "hello" {
+			t.Fatalf("first message = %v, want hello", hello)
+		}
+	}
+
+	for _, id := range []string{"job-1", "job-2", "job-1", "job-1"} {
+		h.Publish(&Event{Type: "job_update", JobID: id, Payload: json.RawMessage(`{}`)})
+	}
+	for i := 0; i < 4; i++ {
+		receive(t, watcher)
+	}
+
+	// Events 3 and 4 are for job-1 and newer than seq 2
+	h.requests <- clientRequest{client: client, message: ClientMessage{ID: "r1", Type: "subscribe", Jobs: []string{"job-1"}, Since: 2}}
+	for _, want := range []float64{3, 4} {
+		if event := receive(t, client); event["type"] != "job_update" || event["seq"] != want {
+			t.Errorf("replayed %v, want job_update %v", event, want)
+		}
+	}
+	if done := receive(t, client); done["type"] != "subscribed" || done["id"] != "r1" || done["replayed"] != float64(2) {
+		t.Errorf("after replay got %v", done)
+	}
+
+	// Unregistering closes the send queue, which stops the writer
+	h.unregister <- client
+	select {
+	case _, ok := <-client.send:
+		if ok {
+			t.Error("unregistered client received a message")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("send queue was not closed")
+	}
+}
+
+func TestSlowClientIsDisconnected(t *testing.T) {
+	h := NewHub()
+	client := &Client{hub: h, send: make(chan []byte, 1), addr: "test"}
+	h.clients[client] = true
+
+	h.sendTo(client, []byte("first"))
+	h.sendTo(client, []byte("second"))
+
+	if h.clients[client] {
+		t.Fatal("a client with a full send queue was kept")
+	}
+	if data, ok := <-client.send; !ok || string(data) != "first" {
+		t.Errorf("queued message = %q, %v; want the first one", data, ok)
+	}
+	if _, ok := <-client.send; ok {
+		t.Error("send queue was not closed")
+	}
+	h.remove(client) // removing twice must not panic
+}
+
+func TestListenerKeepsFinalEvents(t *testing.T) {
+	h := NewHub()
+	go h.run()
+
+	events := h.Listen(1)
+	for i := 0; i < 5; i++ {
+		h.Publish(&Event{Type: "job_update", JobID: "progress"})
+	}
+	published := make(chan struct{})
+	go func() {
+		h.Publish(&Event{Type: "job_update", JobID: "done", Final: true})
+		close(published)
+	}()
+
+	// Only the buffered progress event and the final one arrive; the final
+	// event waited for room instead of being dropped
+	deadline := time.After(2 * time.Second)
+	for {
+		select {
+		case event := <-events:
+			if event.Final {
+				if event.JobID != "done" {
+					t.Errorf("final event for %q", event.JobID)
+				}
+				<-published
+				return
+			}
+		case <-deadline:
+			t.Fatal("the final event was dropped")
+		}
+	}
+}
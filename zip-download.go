@@ -0,0 +1,295 @@
+package main
+
+import (
+	"archive/zip"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Download-all limits
+const (
+	MaxArchiveJobs      = 500
+	DefaultDownloadTTL  = 1 * time.Hour
+	MaxDownloadTTL      = 24 * time.Hour
+	ArchiveManifestName = "manifest.json"
+)
+
+// downloadSigningKey signs GET download-all links. Without
+// DOWNLOAD_SIGNING_KEY a random key is used and links expire on restart.
+var downloadSigningKey []byte
+
+func loadDownloadSigningKey() []byte {
+	if key := os.Getenv("DOWNLOAD_SIGNING_KEY"); key != "" {
+		return []byte(key)
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		log.Fatalf("Cannot generate download signing key: %v", err)
+	}
+	return key
+}
+
+// ArchiveManifest describes the contents of a download-all zip
+type ArchiveManifest struct {
+	CreatedAt time.Time       `json:"created_at"`
+	Jobs      []ArchiveRecord `json:"jobs"`
+	Missing   []string        `json:"missing,omitempty"`
+}
+
+// ArchiveRecord maps a job to its entries in the zip
+type ArchiveRecord struct {
+	JobID    string            `json:"job_id"`
+	Source   string            `json:"source"`
+	Output   string            `json:"output"`
+	Size     int64             `json:"size"`
+	Sidecars []string          `json:"sidecars,omitempty"`
+	Options  ConversionOptions `json:"options"`
+}
+
+// snapshotCompletedJobs copies the completed jobs so the archive can be
+// written without holding the queue lock. Unknown IDs are returned as missing.
+func snapshotCompletedJobs(jobIDs []string) ([]Job, []string) {
+	jobs := make([]Job, 0, len(jobIDs))
+	missing := make([]string, 0)
+	seen := make(map[string]bool)
+
+	queue.mu.RLock()
+	defer queue.mu.RUnlock()
+
+	for _, id := range jobIDs {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		if job, exists := queue.completed[id]; exists {
+			jobs = append(jobs, *job)
+		} else {
+			missing = append(missing, id)
+		}
+	}
+	return jobs, missing
+}
+
+// writeJobsArchive streams the outputs, extracted subtitles and a manifest
+// as an uncompressed zip; MP4 segments don't get smaller with deflate
+func writeJobsArchive(w io.Writer, jobs []Job, missing []string) error {
+	zipWriter := zip.NewWriter(w)
+	used := map[string]bool{ArchiveManifestName: true}
+	manifest := ArchiveManifest{CreatedAt: time.Now(), Jobs: make([]ArchiveRecord, 0, len(jobs)), Missing: missing}
+
+	for i := range jobs {
+		job := &jobs[i]
+		record := ArchiveRecord{JobID: job.ID, Source: job.FileName, Options: job.Options}
+
+		var err error
+		if job.Options.IsStreaming() {
+			folder := uniqueArchiveName(used, strings.TrimSuffix(job.OutputName, filepath.Ext(job.OutputName)))
+			record.Output = folder + "/"
+			record.Size, err = addDirToZip(zipWriter, streamDir(job), folder)
+		} else {
+			record.Output = uniqueArchiveName(used, job.OutputName)
+			record.Size, err = addFileToZip(zipWriter, filepath.Join(OutputDir, job.ID+"_"+job.OutputName), record.Output)
+		}
+		if err != nil {
+			return fmt.Errorf("job %s: %v", job.ID, err)
+		}
+
+		for _, name := range job.Sidecars {
+			entry := uniqueArchiveName(used, name)
+			if _, err := addFileToZip(zipWriter, assetPath(job, name), entry); err != nil {
+				return fmt.Errorf("job %s: %v", job.ID, err)
+			}
+			record.Sidecars = append(record.Sidecars, entry)
+		}
+
+		manifest.Jobs = append(manifest.Jobs, record)
+	}
+
+	entry, err := createStoredEntry(zipWriter, ArchiveManifestName, manifest.CreatedAt)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(entry)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(manifest); err != nil {
+		return err
+	}
+
+	return zipWriter.Close()
+}
+
+func createStoredEntry(zipWriter *zip.Writer, name string, modified time.Time) (io.Writer, error) {
+	return zipWriter.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Store,
+		Modified: modified,
+	})
+}
+
+func addFileToZip(zipWriter *zip.Writer, path, name string) (int64, error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	dst, err := createStoredEntry(zipWriter, name, info.ModTime())
+	if err != nil {
+		return 0, err
+	}
+	return io.Copy(dst, src)
+}
+
+// addDirToZip adds every file under dir below folder/ and returns the total size
+func addDirToZip(zipWriter *zip.Writer, dir, folder string) (int64, error) {
+	var total int64
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		n, err := addFileToZip(zipWriter, path, folder+"/"+filepath.ToSlash(rel))
+		total += n
+		return err
+	})
+	return total, err
+}
+
+// uniqueArchiveName returns name, or "name (2).ext" etc. if already taken
+func uniqueArchiveName(used map[string]bool, name string) string {
+	candidate := name
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 2; used[strings.ToLower(candidate)]; i++ {
+		candidate = fmt.Sprintf("%s (%d)%s", base, i, ext)
+	}
+	used[strings.ToLower(candidate)] = true
+	return candidate
+}
+
+func serveJobsArchive(w http.ResponseWriter, jobIDs []string) {
+	if len(jobIDs) == 0 || len(jobIDs) > MaxArchiveJobs {
+		http.Error(w, fmt.Sprintf("Between 1 and %d job IDs are required", MaxArchiveJobs), http.StatusBadRequest)
+		return
+	}
+
+	jobs, missing := snapshotCompletedJobs(jobIDs)
+	if len(jobs) == 0 {
+		http.Error(w, "None of the jobs are completed", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"converted_videos.zip\"")
+
+	// Headers are already sent; a failure can only truncate the archive
+	if err := writeJobsArchive(w, jobs, missing); err != nil {
+		log.Printf("Download-all failed: %v", err)
+	}
+}
+
+// signDownload returns the hex HMAC for a job list and expiry
+func signDownload(jobIDs []string, expires int64) string {
+	mac := hmac.New(sha256.New, downloadSigningKey)
+	mac.Write([]byte(strings.Join(jobIDs, ",") + "|" + strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Download-all handlers
+
+func handleDownloadAll(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		JobIDs []string `json:"job_ids"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	serveJobsArchive(w, request.JobIDs)
+}
+
+// handleSignedDownloadAll serves GET /api/jobs/download-all?ids=..&expires=..&sig=..
+func handleSignedDownloadAll(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	jobIDs := strings.Split(query.Get("ids"), ",")
+
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		http.Error(w, "Download link expired", http.StatusGone)
+		return
+	}
+	if !hmac.Equal([]byte(signDownload(jobIDs, expires)), []byte(query.Get("sig"))) {
+		http.Error(w, "Invalid signature", http.StatusForbidden)
+		return
+	}
+
+	serveJobsArchive(w, jobIDs)
+}
+
+// handleSignDownloadAll returns a signed GET link for a job list, which
+// browsers can download directly without buffering the zip in memory
+func handleSignDownloadAll(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		JobIDs     []string `json:"job_ids"`
+		TTLSeconds int      `json:"ttl_seconds"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(request.JobIDs) == 0 || len(request.JobIDs) > MaxArchiveJobs {
+		http.Error(w, fmt.Sprintf("Between 1 and %d job IDs are required", MaxArchiveJobs), http.StatusBadRequest)
+		return
+	}
+	for _, id := range request.JobIDs {
+		if id == "" || strings.Contains(id, ",") {
+			http.Error(w, "Invalid job ID", http.StatusBadRequest)
+			return
+		}
+	}
+
+	ttl := DefaultDownloadTTL
+	if request.TTLSeconds > 0 {
+		ttl = time.Duration(request.TTLSeconds) * time.Second
+		if ttl > MaxDownloadTTL {
+			ttl = MaxDownloadTTL
+		}
+	}
+	expires := time.Now().Add(ttl).Unix()
+
+	query := url.Values{}
+	query.Set("ids", strings.Join(request.JobIDs, ","))
+	query.Set("expires", strconv.FormatInt(expires, 10))
+	query.Set("sig", signDownload(request.JobIDs, expires))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"url":     "/api/jobs/download-all?" + query.Encode(),
+		"expires": time.Unix(expires, 0),
+	})
+}
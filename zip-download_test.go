@@ -0,0 +1,46 @@
+package main
+
+import "testing"
+
+func TestUniqueArchiveName(t *testing.T) {
+	used := make(map[string]bool)
+	names := []struct {
+		in   string
+		want string
+	}{
+		{"clip.mp4", "clip.mp4"},
+		{"clip.mp4", "clip (2).mp4"},
+		{"CLIP.mp4", "CLIP (3).mp4"},
+		{"clip (2).mp4", "clip (2) (2).mp4"},
+		{"clip.mkv", "clip.mkv"},
+		{"notes", "notes"},
+		{"notes", "notes (2)"},
+	}
+
+	for _, tt := range names {
+		if got := uniqueArchiveName(used, tt.in); got != tt.want {
+			t.Errorf("uniqueArchiveName(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSignDownload(t *testing.T) {
+	ids := []string{"a", "b"}
+	sig := signDownload(ids, 1000)
+
+	if len(sig) != 64 {
+		t.Errorf("signature %q is not a hex SHA-256", sig)
+	}
+	if signDownload(ids, 1000) != sig {
+		t.Error("signature is not deterministic")
+	}
+	for name, other := range map[string]string{
+		"expiry": signDownload(ids, 1001),
+		"ids":    signDownload([]string{"a", "c"}, 1000),
+		"order":  signDownload([]string{"b", "a"}, 1000),
+	} {
+		if other == sig {
+			t.Errorf("changing the %s keeps the same signature", name)
+		}
+	}
+}